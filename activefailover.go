@@ -0,0 +1,112 @@
+//
+// DISCLAIMER
+//
+// Copyright 2018 ArangoDB GmbH, Cologne, Germany
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Copyright holder is ArangoDB GmbH, Cologne, Germany
+//
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	driver "github.com/arangodb/go-driver"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+// afLeaderOnly skips all other collectors when the server is a follower in Active Failover.
+var afLeaderOnly = false
+
+// activeFailoverCollector exports the role of the server in an Active Failover deployment
+// and, for followers, how far they lag behind the leader.
+// A server is a follower when it is not available and its global replication applier is running.
+type activeFailoverCollector struct {
+	isLeader, leaderInfo, lag *prometheus.Desc
+}
+
+// newActiveFailoverCollector returns an activeFailoverCollector adding the given labels to its metrics.
+func newActiveFailoverCollector(labels prometheus.Labels) *activeFailoverCollector {
+	return &activeFailoverCollector{
+		isLeader: prometheus.NewDesc(prometheus.BuildFQName(namespace, "af", "is_leader"),
+			"Is the server the leader of the Active Failover deployment.", nil, labels),
+		leaderInfo: prometheus.NewDesc(prometheus.BuildFQName(namespace, "af", "leader_info"),
+			"Endpoint of the leader of the Active Failover deployment.", []string{"endpoint"}, labels),
+		lag: prometheus.NewDesc(prometheus.BuildFQName(namespace, "af", "follower_lag_ticks"),
+			"Number of ticks available on the leader that the follower has not applied yet.", nil, labels),
+	}
+}
+
+// collect implements collector.
+func (c *activeFailoverCollector) collect(ctx context.Context, s *scrape) ([]prometheus.Metric, error) {
+	conn, err := s.connection()
+	if err != nil {
+		return nil, maskAny(err)
+	}
+
+	// Only the leader is available, followers respond with 503
+	leader := true
+	var state ReplicationApplierState
+	if err := GetServerAvailability(withoutRetries(ctx), conn); driver.IsArangoErrorWithCode(errors.Cause(err), http.StatusServiceUnavailable) {
+		// A leader that is starting, stopping or in maintenance responds with 503 as well.
+		// Only followers replicate from the leader.
+		if state, err = GetReplicationApplierState(ctx, conn); err != nil {
+			log.Errorf("Failed to fetch replication applier state: %v", err)
+			return nil, maskAny(err)
+		}
+		if !state.State.Running {
+			return nil, maskAny(fmt.Errorf("Server is not available and does not replicate from a leader"))
+		}
+		leader = false
+	} else if err != nil {
+		log.Errorf("Failed to fetch server availability: %v", err)
+		return nil, maskAny(err)
+	}
+	s.follower = !leader
+	isLeader := 0.0
+	if leader {
+		isLeader = 1
+	}
+	result := []prometheus.Metric{prometheus.MustNewConstMetric(c.isLeader, prometheus.GaugeValue, isLeader)}
+
+	// The leader is the first endpoint
+	endpoints, err := GetClusterEndpoints(ctx, conn)
+	if err != nil {
+		log.Errorf("Failed to fetch Active Failover endpoints: %v", err)
+		return result, maskAny(err)
+	}
+	if len(endpoints.Endpoints) > 0 {
+		result = append(result, prometheus.MustNewConstMetric(c.leaderInfo, prometheus.GaugeValue, 1, endpoints.Endpoints[0].Endpoint))
+	}
+
+	if leader {
+		return result, nil
+	}
+	applied, appliedErr := strconv.ParseUint(state.State.LastAppliedContinuousTick, 10, 64)
+	available, availableErr := strconv.ParseUint(state.State.LastAvailableContinuousTick, 10, 64)
+	if appliedErr == nil && availableErr == nil {
+		lag := 0.0
+		if available > applied {
+			lag = float64(available - applied)
+		}
+		result = append(result, prometheus.MustNewConstMetric(c.lag, prometheus.GaugeValue, lag))
+	}
+	return result, nil
+}
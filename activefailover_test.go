@@ -0,0 +1,119 @@
+//
+// DISCLAIMER
+//
+// Copyright 2018 ArangoDB GmbH, Cologne, Germany
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Copyright holder is ArangoDB GmbH, Cologne, Germany
+//
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// TestActiveFailoverCollector tests the metrics of leaders & followers, only scraping the leader.
+// A leader that is not available, e.g. during maintenance, is not mistaken for a follower.
+func TestActiveFailoverCollector(t *testing.T) {
+	defer func(enabled, leaderOnly bool) {
+		*collectorEnabled[collectorActiveFailover] = enabled
+		afLeaderOnly = leaderOnly
+	}(*collectorEnabled[collectorActiveFailover], afLeaderOnly)
+	*collectorEnabled[collectorActiveFailover] = true
+	afLeaderOnly = true
+
+	const (
+		stateLeader      = 0
+		stateFollower    = 1
+		stateUnavailable = 2 // Leader in maintenance
+	)
+	var serverState, statistics int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/_admin/server/availability":
+			if atomic.LoadInt32(&serverState) != stateLeader {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				w.Write([]byte(`{"error":true,"code":503,"errorNum":1495}`))
+				return
+			}
+			w.Write([]byte(`{"mode":"default","writeOps":"enabled"}`))
+		case "/_api/cluster/endpoints":
+			w.Write([]byte(`{"error":false,"code":200,"endpoints":[{"endpoint":"tcp://leader:8529"},{"endpoint":"tcp://follower:8529"}]}`))
+		case "/_api/replication/applier-state":
+			if atomic.LoadInt32(&serverState) != stateFollower {
+				w.Write([]byte(`{"state":{"running":false}}`))
+				return
+			}
+			w.Write([]byte(`{"state":{"running":true,"lastAppliedContinuousTick":"1000","lastAvailableContinuousTick":"1042"}}`))
+		case "/_admin/statistics":
+			atomic.AddInt32(&statistics, 1)
+			w.Write([]byte(`{"server":{"uptime":12.5}}`))
+		case "/_admin/statistics-description":
+			w.Write([]byte(`{"groups":[],"figures":[]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	e, _ := NewExporter(server.URL, func() (string, error) { return "", nil }, false, time.Second)
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(e)
+
+	tests := []struct {
+		State      int32
+		Expected   map[string]float64
+		Statistics int32
+	}{
+		{stateLeader, map[string]float64{"arangodb_af_is_leader": 1, "arangodb_af_leader_info": 1, "arangodb_server_uptime_seconds": 12.5, "arangodb_up": 1}, 1},
+		{stateFollower, map[string]float64{"arangodb_af_is_leader": 0, "arangodb_af_follower_lag_ticks": 42, "arangodb_up": 1}, 1},
+		{stateUnavailable, map[string]float64{"arangodb_server_uptime_seconds": 12.5, "arangodb_up": 1}, 2},
+	}
+
+	for i, test := range tests {
+		atomic.StoreInt32(&serverState, test.State)
+		families, err := registry.Gather()
+		if err != nil {
+			t.Fatalf("Gather for test %d failed: %v", i, err)
+		}
+		values := make(map[string]float64)
+		for _, mf := range families {
+			for _, m := range mf.GetMetric() {
+				values[mf.GetName()] = m.GetGauge().GetValue()
+			}
+		}
+		for name, value := range test.Expected {
+			if v, found := values[name]; !found || v != value {
+				t.Errorf("Gather for test %d returns unexpected %s: got %v (found %v), expected %v", i, name, v, found, value)
+			}
+		}
+		if _, found := values["arangodb_af_follower_lag_ticks"]; found && test.State != stateFollower {
+			t.Errorf("Gather for test %d returns the lag of a leader", i)
+		}
+		if _, found := values["arangodb_af_is_leader"]; found && test.State == stateUnavailable {
+			t.Errorf("Gather for test %d returns the role of an unavailable server", i)
+		}
+		if n := atomic.LoadInt32(&statistics); n != test.Statistics {
+			t.Errorf("Gather for test %d failed: got %d statistics requests, expected %d", i, n, test.Statistics)
+		}
+	}
+}
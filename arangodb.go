@@ -175,3 +175,149 @@ func GetStatisticsDescription(ctx context.Context, conn driver.Connection) (Stat
 	}
 	return result, nil
 }
+
+// ClusterEndpoints is the JSON representation of the result of an _api/cluster/endpoints call.
+type ClusterEndpoints struct {
+	Endpoints []ClusterEndpoint `json:"endpoints"`
+}
+
+// ClusterEndpoint describes a single endpoint of a cluster.
+type ClusterEndpoint struct {
+	Endpoint string `json:"endpoint"`
+}
+
+// GetClusterEndpoints requests the endpoints of the cluster from the given connection.
+func GetClusterEndpoints(ctx context.Context, conn driver.Connection) (ClusterEndpoints, error) {
+	req, err := conn.NewRequest("GET", "_api/cluster/endpoints")
+	if err != nil {
+		return ClusterEndpoints{}, maskAny(err)
+	}
+	resp, err := conn.Do(ctx, req)
+	if err != nil {
+		return ClusterEndpoints{}, maskAny(err)
+	}
+	if err := resp.CheckStatus(200); err != nil {
+		return ClusterEndpoints{}, maskAny(err)
+	}
+	var result ClusterEndpoints
+	if err := resp.ParseBody("", &result); err != nil {
+		return ClusterEndpoints{}, maskAny(err)
+	}
+	return result, nil
+}
+
+// GetClusterHealth requests the health of all cluster members from the given connection.
+func GetClusterHealth(ctx context.Context, conn driver.Connection) (driver.ClusterHealth, error) {
+	req, err := conn.NewRequest("GET", "_admin/cluster/health")
+	if err != nil {
+		return driver.ClusterHealth{}, maskAny(err)
+	}
+	resp, err := conn.Do(ctx, req)
+	if err != nil {
+		return driver.ClusterHealth{}, maskAny(err)
+	}
+	if err := resp.CheckStatus(200); err != nil {
+		return driver.ClusterHealth{}, maskAny(err)
+	}
+	var result driver.ClusterHealth
+	if err := resp.ParseBody("", &result); err != nil {
+		return driver.ClusterHealth{}, maskAny(err)
+	}
+	return result, nil
+}
+
+// GetVersion requests the version of the server from the given connection.
+func GetVersion(ctx context.Context, conn driver.Connection) (driver.VersionInfo, error) {
+	req, err := conn.NewRequest("GET", "_api/version")
+	if err != nil {
+		return driver.VersionInfo{}, maskAny(err)
+	}
+	resp, err := conn.Do(ctx, req)
+	if err != nil {
+		return driver.VersionInfo{}, maskAny(err)
+	}
+	if err := resp.CheckStatus(200); err != nil {
+		return driver.VersionInfo{}, maskAny(err)
+	}
+	var result driver.VersionInfo
+	if err := resp.ParseBody("", &result); err != nil {
+		return driver.VersionInfo{}, maskAny(err)
+	}
+	return result, nil
+}
+
+// ServerID is the JSON representation of the result of an _admin/server/id call.
+type ServerID struct {
+	ID string `json:"id"`
+}
+
+// GetServerID requests the ID of the server from the given connection.
+// Only servers in a cluster have an ID, other servers respond with an error.
+func GetServerID(ctx context.Context, conn driver.Connection) (ServerID, error) {
+	req, err := conn.NewRequest("GET", "_admin/server/id")
+	if err != nil {
+		return ServerID{}, maskAny(err)
+	}
+	resp, err := conn.Do(ctx, req)
+	if err != nil {
+		return ServerID{}, maskAny(err)
+	}
+	if err := resp.CheckStatus(200); err != nil {
+		return ServerID{}, maskAny(err)
+	}
+	var result ServerID
+	if err := resp.ParseBody("", &result); err != nil {
+		return ServerID{}, maskAny(err)
+	}
+	return result, nil
+}
+
+// GetServerAvailability checks that the server is available using the given connection.
+// The followers of an Active Failover deployment respond with an error, like servers that are starting or stopping.
+func GetServerAvailability(ctx context.Context, conn driver.Connection) error {
+	req, err := conn.NewRequest("GET", "_admin/server/availability")
+	if err != nil {
+		return maskAny(err)
+	}
+	resp, err := conn.Do(ctx, req)
+	if err != nil {
+		return maskAny(err)
+	}
+	if err := resp.CheckStatus(200); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
+
+// ReplicationApplierState is the JSON representation of the result of an _api/replication/applier-state call.
+type ReplicationApplierState struct {
+	State struct {
+		Running                     bool   `json:"running"`
+		LastAppliedContinuousTick   string `json:"lastAppliedContinuousTick"`
+		LastAvailableContinuousTick string `json:"lastAvailableContinuousTick"`
+	} `json:"state"`
+	Endpoint string `json:"endpoint"`
+}
+
+// GetReplicationApplierState requests the state of the global replication applier from the given connection.
+// Followers in Active Failover replicate from their leader using the global applier.
+func GetReplicationApplierState(ctx context.Context, conn driver.Connection) (ReplicationApplierState, error) {
+	req, err := conn.NewRequest("GET", "_api/replication/applier-state")
+	if err != nil {
+		return ReplicationApplierState{}, maskAny(err)
+	}
+	req.SetQuery("global", "true")
+	req.SetHeader("x-arango-allow-dirty-read", "true") // Followers in AF mode only respond to dirty reads
+	resp, err := conn.Do(ctx, req)
+	if err != nil {
+		return ReplicationApplierState{}, maskAny(err)
+	}
+	if err := resp.CheckStatus(200); err != nil {
+		return ReplicationApplierState{}, maskAny(err)
+	}
+	var result ReplicationApplierState
+	if err := resp.ParseBody("", &result); err != nil {
+		return ReplicationApplierState{}, maskAny(err)
+	}
+	return result, nil
+}
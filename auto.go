@@ -0,0 +1,276 @@
+//
+// DISCLAIMER
+//
+// Copyright 2018 ArangoDB GmbH, Cologne, Germany
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Copyright holder is ArangoDB GmbH, Cologne, Germany
+//
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	driver "github.com/arangodb/go-driver"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/log"
+	"github.com/prometheus/common/version"
+)
+
+const (
+	// passthruMinVersion is the first ArangoDB version that exposes its metrics in Prometheus format.
+	passthruMinVersion = driver.Version("3.6.0")
+)
+
+var _ http.Handler = &autoMode{}
+
+// NewAutoMode returns a handler that selects the passthru mode for ArangoDB servers
+// that expose their metrics in Prometheus format and the internal mode otherwise.
+// The server version is detected at startup and again after a failed scrape,
+// so the mode follows the server through upgrades.
+func NewAutoMode(arangodbEndpoint string, auth Authentication, sslVerify bool, timeout time.Duration, passthruCfg PassthruConfig) (http.Handler, error) {
+	// Polling is only supported in internal & passthru mode
+	passthruCfg.Poll = PollConfig{}
+
+	// Both modes share the endpoint selected by the passthru, so the mode is detected for the server that is scraped
+	passthru := newPassthru(arangodbEndpoint, auth, sslVerify, timeout, passthruCfg)
+	exporter := newExporter(arangodbEndpoint, auth, sslVerify, timeout, nil, passthru.endpoints)
+
+	info := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "exporter_mode",
+		Help:      "Mode used by the exporter to expose the metrics of the ArangoDB server.",
+	}, []string{"mode"})
+	modeRegistry := prometheus.NewRegistry()
+	if err := modeRegistry.Register(info); err != nil {
+		return nil, maskAny(err)
+	}
+	// The passthru exposes the build info & selected endpoint itself, in internal mode they are added here
+	if err := modeRegistry.Register(version.NewCollector("arangodb_exporter")); err != nil {
+		return nil, maskAny(err)
+	}
+	if err := modeRegistry.Register(passthru.endpoints); err != nil {
+		return nil, maskAny(err)
+	}
+
+	// The passthru exposes the mode metric together with its own metrics
+	if err := passthru.metrics.Register(info); err != nil {
+		return nil, maskAny(err)
+	}
+
+	a := &autoMode{
+		auth:         auth,
+		transport:    newDriverTransport(sslVerify, timeout),
+		timeout:      timeout,
+		internal:     exporter,
+		source:       newScrapeSource(exporter, passthruCfg.CacheMaxAge),
+		passthru:     passthru,
+		info:         info,
+		modeRegistry: modeRegistry,
+	}
+	// Another server may run another version
+	passthru.endpoints.subscribe(a.reconnect)
+	a.mode(context.Background())
+
+	return a, nil
+}
+
+type autoMode struct {
+	auth         Authentication
+	transport    *http.Transport
+	timeout      time.Duration
+	internal     *Exporter
+	source       *scrapeSource
+	passthru     *passthru
+	info         *prometheus.GaugeVec
+	modeRegistry *prometheus.Registry
+
+	mutex     sync.Mutex
+	current   ExporterMode
+	detected  bool
+	detecting bool
+}
+
+func (a *autoMode) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	ctx, cancel := scrapeContext(req)
+	defer cancel()
+
+	switch a.mode(ctx) {
+	case ModePassthru:
+		a.servePassthru(resp, req)
+	default:
+		a.serveInternal(resp, req)
+	}
+}
+
+// mode returns the mode to use for the server, detecting it within the given context & the timeout when needed.
+// When detection fails, the last detected mode is used. Detection is done without holding the lock,
+// concurrent scrapes use the last detected mode instead of waiting for it.
+func (a *autoMode) mode(ctx context.Context) ExporterMode {
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
+
+	// Selecting the endpoint can switch to another server, which calls reconnect
+	endpoint := a.passthru.endpoints.get(ctx)
+
+	a.mutex.Lock()
+	if a.detected || a.detecting {
+		defer a.mutex.Unlock()
+		if a.current == "" {
+			return ModeInternal
+		}
+		return a.current
+	}
+	a.detecting = true
+	a.mutex.Unlock()
+
+	mode, version, err := a.detect(ctx, endpoint)
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.detecting = false
+	if err != nil {
+		log.Warnf("Failed to detect ArangoDB version: %v", err)
+		if a.current == "" {
+			// Internal mode is served until the first detection succeeds
+			a.info.Reset()
+			a.info.WithLabelValues(string(ModeInternal)).Set(1)
+			return ModeInternal
+		}
+		return a.current
+	}
+
+	a.detected = true
+	if mode != a.current {
+		if a.current == "" {
+			log.Infof("Using %s mode for ArangoDB %s", mode, version)
+		} else {
+			log.Infof("Switching from %s to %s mode for ArangoDB %s", a.current, mode, version)
+		}
+		a.current = mode
+		a.info.Reset()
+		a.info.WithLabelValues(string(mode)).Set(1)
+	}
+	return mode
+}
+
+// reconnect ensures the mode is detected again on the next scrape.
+func (a *autoMode) reconnect() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.detected = false
+}
+
+// detect returns the mode matching the version of the server at the given endpoint,
+// which is selected by the passthru and shared with the internal exporter.
+func (a *autoMode) detect(ctx context.Context, endpoint string) (ExporterMode, driver.Version, error) {
+	conn, err := newConnClientFactory(endpoint, a.auth, a.transport)()
+	if err != nil {
+		return "", "", maskAny(err)
+	}
+	v, err := GetVersion(ctx, conn)
+	if err != nil {
+		return "", "", maskAny(err)
+	}
+	if v.Version.CompareTo(passthruMinVersion) >= 0 {
+		return ModePassthru, v.Version, nil
+	}
+	return ModeInternal, v.Version, nil
+}
+
+// servePassthru serves the passthru metrics, including the mode metric.
+func (a *autoMode) servePassthru(resp http.ResponseWriter, req *http.Request) {
+	rec := &statusRecorder{ResponseWriter: resp, status: http.StatusOK}
+	a.passthru.ServeHTTP(rec, req)
+	if rec.status != http.StatusOK {
+		a.reconnect()
+		return
+	}
+	// Failed scrapes are reported with status 200 when errors are exposed as arangodb_up 0
+	if families, err := a.passthru.scrape.Gather(); err == nil && !isUp(families) {
+		a.reconnect()
+	}
+}
+
+// serveInternal serves the internal metrics, together with the mode metric.
+func (a *autoMode) serveInternal(resp http.ResponseWriter, req *http.Request) {
+	ctx, cancel := scrapeContext(req)
+	defer cancel()
+
+	internal, err := a.source.gatherer(ctx)
+	if err != nil {
+		http.Error(resp, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	families, err := prometheus.Gatherers{internal, a.modeRegistry}.Gather()
+	if err != nil {
+		http.Error(resp, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !isUp(families) {
+		a.reconnect()
+	}
+
+	enc := newResponseEncoder(resp, req)
+	for _, mf := range families {
+		if err := enc.Encode(mf); err != nil {
+			log.Errorf("Failed to encode metrics: %v", err)
+			return
+		}
+	}
+	if err := enc.Close(); err != nil {
+		log.Errorf("Failed to encode metrics: %v", err)
+	}
+}
+
+// CloseIdleConnections closes the idle connections to ArangoDB of both modes.
+func (a *autoMode) CloseIdleConnections() {
+	a.internal.CloseIdleConnections()
+	a.passthru.CloseIdleConnections()
+	a.transport.CloseIdleConnections()
+}
+
+// isUp returns true if the given metric families report the server as up.
+func isUp(families []*dto.MetricFamily) bool {
+	for _, mf := range families {
+		if mf.GetName() != namespace+"_up" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			if m.GetGauge().GetValue() != 1 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// statusRecorder is a http.ResponseWriter that records the status code of the response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
@@ -0,0 +1,145 @@
+//
+// DISCLAIMER
+//
+// Copyright 2018 ArangoDB GmbH, Cologne, Germany
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Copyright holder is ArangoDB GmbH, Cologne, Germany
+//
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeAutoServer is an ArangoDB server of which the version can be changed.
+// It responds to statistics requests with an error, so scrapes in internal mode fail.
+type fakeAutoServer struct {
+	mutex   sync.Mutex
+	version string // Empty to respond to version requests with an error
+}
+
+func (s *fakeAutoServer) setVersion(version string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.version = version
+}
+
+func (s *fakeAutoServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	version := s.version
+	s.mutex.Unlock()
+
+	switch r.URL.Path {
+	case "/_api/version":
+		if version == "" {
+			http.Error(w, `{"error":true,"code":503}`, http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"server":"arango","version":"%s"}`, version)
+	case "/_admin/metrics":
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintln(w, "arangodb_client_connection_statistics_total 1")
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// modeInfo returns the mode exposed in the mode metric, empty if none is set.
+func modeInfo(t *testing.T, a *autoMode) string {
+	families, err := a.modeRegistry.Gather()
+	if err != nil {
+		t.Fatalf("Gather failed: %v", err)
+	}
+	for _, mf := range families {
+		if mf.GetName() != namespace+"_exporter_mode" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			if m.GetGauge().GetValue() == 1 {
+				return m.GetLabel()[0].GetValue()
+			}
+		}
+	}
+	return ""
+}
+
+func newTestAutoMode(t *testing.T, url string) *autoMode {
+	handler, err := NewAutoMode(url, func() (string, error) { return "", nil }, false, time.Second, PassthruConfig{MetricsAPI: MetricsAPIV1})
+	if err != nil {
+		t.Fatalf("NewAutoMode failed: %v", err)
+	}
+	return handler.(*autoMode)
+}
+
+// TestAutoModeDetection tests the mode detected for various server versions.
+func TestAutoModeDetection(t *testing.T) {
+	tests := []struct {
+		Version string
+		Mode    ExporterMode
+	}{
+		{"3.4.10", ModeInternal},
+		{"3.5.7", ModeInternal},
+		{"3.6.0", ModePassthru},
+		{"3.10.1", ModePassthru},
+		{"", ModeInternal}, // Detection fails
+	}
+
+	for i, test := range tests {
+		fake := &fakeAutoServer{version: test.Version}
+		server := httptest.NewServer(fake)
+		a := newTestAutoMode(t, server.URL)
+		if mode := a.mode(context.Background()); mode != test.Mode {
+			t.Errorf("mode for test %d (%s) failed: got %s, expected %s", i, test.Version, mode, test.Mode)
+		}
+		if info := modeInfo(t, a); info != string(test.Mode) {
+			t.Errorf("Mode metric for test %d (%s) is %s, expected %s", i, test.Version, info, test.Mode)
+		}
+		server.Close()
+	}
+}
+
+// TestAutoModeRedetection tests that the mode is detected again after a failed scrape only.
+func TestAutoModeRedetection(t *testing.T) {
+	fake := &fakeAutoServer{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	a := newTestAutoMode(t, server.URL)
+	tests := []struct {
+		Version string
+		Mode    ExporterMode
+	}{
+		{"", ModeInternal},       // Detection fails, internal mode is used
+		{"3.5.7", ModeInternal},  // Detected after the failed scrape, the internal scrape fails again
+		{"3.7.12", ModePassthru}, // Upgraded, detected after the failed scrape
+		{"3.5.7", ModePassthru},  // Not detected again after a successful scrape
+	}
+
+	for i, test := range tests {
+		fake.setVersion(test.Version)
+		a.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/metrics", nil))
+		if info := modeInfo(t, a); info != string(test.Mode) {
+			t.Errorf("Mode metric for test %d (%s) is %s, expected %s", i, test.Version, info, test.Mode)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+//
+// DISCLAIMER
+//
+// Copyright 2018 ArangoDB GmbH, Cologne, Germany
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Copyright holder is ArangoDB GmbH, Cologne, Germany
+//
+
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"golang.org/x/sync/singleflight"
+)
+
+var _ prometheus.Gatherer = &scrapeCache{}
+
+// gathererFunc is a function that implements prometheus.Gatherer.
+type gathererFunc func() ([]*dto.MetricFamily, error)
+
+// Gather implements prometheus.Gatherer.
+func (f gathererFunc) Gather() ([]*dto.MetricFamily, error) {
+	return f()
+}
+
+// scrapeCache caches the metric families of a gatherer for a maximum age.
+// Concurrent gathers without a fresh cached result are merged into a single gather
+// of the underlying gatherer, of which the result is returned to all callers.
+// Only successful results are cached. The returned metric families must not be modified.
+type scrapeCache struct {
+	gatherer prometheus.Gatherer
+	maxAge   time.Duration
+	group    singleflight.Group
+	age      prometheus.GaugeFunc
+
+	mutex    sync.Mutex
+	families []*dto.MetricFamily
+	gathered time.Time
+}
+
+// newScrapeCache returns a cache of the metric families of the given gatherer.
+// The age of the cached metrics is exposed by the collector returned by ageCollector,
+// with the given client as label, to tell the caches of a target apart.
+func newScrapeCache(gatherer prometheus.Gatherer, maxAge time.Duration, client string) *scrapeCache {
+	c := &scrapeCache{
+		gatherer: gatherer,
+		maxAge:   maxAge,
+	}
+	c.age = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Name:        "exporter_cache_age_seconds",
+		Help:        "Age of the cached ArangoDB metrics in seconds, 0 if nothing is cached.",
+		ConstLabels: prometheus.Labels{"client": client},
+	}, c.ageSeconds)
+	return c
+}
+
+// ageCollector returns the collector exposing the age of the cached metrics.
+func (c *scrapeCache) ageCollector() prometheus.Collector {
+	return c.age
+}
+
+// Gather returns the cached metric families when they are not older than the maximum age,
+// and gathers them otherwise. It implements prometheus.Gatherer.
+func (c *scrapeCache) Gather() ([]*dto.MetricFamily, error) {
+	if families, found := c.cached(); found {
+		return families, nil
+	}
+
+	type result struct {
+		families []*dto.MetricFamily
+		err      error
+	}
+	r, _, _ := c.group.Do("gather", func() (interface{}, error) {
+		// Another gather may have completed while waiting
+		if families, found := c.cached(); found {
+			return result{families: families}, nil
+		}
+		families, err := c.gatherer.Gather()
+		if err == nil {
+			c.mutex.Lock()
+			c.families = families
+			c.gathered = time.Now()
+			c.mutex.Unlock()
+		}
+		// Gatherers may return partial results together with an error, both are passed to all callers
+		return result{families: families, err: err}, nil
+	})
+	res := r.(result)
+	return res.families, res.err
+}
+
+// cached returns the cached metric families, if they are not older than the maximum age.
+func (c *scrapeCache) cached() ([]*dto.MetricFamily, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.families == nil || time.Since(c.gathered) > c.maxAge {
+		return nil, false
+	}
+	return c.families, true
+}
+
+// ageSeconds returns the age of the cached metric families in seconds, 0 if nothing is cached.
+func (c *scrapeCache) ageSeconds() float64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.gathered.IsZero() {
+		return 0
+	}
+	return time.Since(c.gathered).Seconds()
+}
+
+// scrapeSource provides the metrics of a collector for scrapes. When a maximum age is given,
+// they are served from a cache, which collects them independently of the scrapes.
+type scrapeSource struct {
+	collector contextCollector
+	cache     *scrapeCache
+	cacheAge  *prometheus.Registry
+}
+
+// newScrapeSource returns a source of the metrics of the given collector, cached for the given maximum age.
+// A maximum age of 0 disables the cache.
+func newScrapeSource(c contextCollector, maxAge time.Duration) *scrapeSource {
+	s := &scrapeSource{collector: c}
+	if maxAge > 0 {
+		s.cache = newScrapeCache(gathererFunc(func() ([]*dto.MetricFamily, error) {
+			g, err := scrapeGatherer(context.Background(), c)
+			if err != nil {
+				return nil, maskAny(err)
+			}
+			return g.Gather()
+		}), maxAge, "internal")
+		s.cacheAge = prometheus.NewRegistry()
+		s.cacheAge.MustRegister(s.cache.ageCollector())
+	}
+	return s
+}
+
+// gatherer returns a gatherer of the metrics for a scrape within the given context,
+// together with the age of the cached metrics when the cache is enabled.
+func (s *scrapeSource) gatherer(ctx context.Context) (prometheus.Gatherer, error) {
+	if s.cache != nil {
+		return prometheus.Gatherers{s.cache, s.cacheAge}, nil
+	}
+	return scrapeGatherer(ctx, s.collector)
+}
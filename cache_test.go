@@ -0,0 +1,133 @@
+//
+// DISCLAIMER
+//
+// Copyright 2018 ArangoDB GmbH, Cologne, Germany
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Copyright holder is ArangoDB GmbH, Cologne, Germany
+//
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// TestScrapeCache tests that gathers are served from the cache and concurrent gathers are merged.
+func TestScrapeCache(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	fail := false
+	cache := newScrapeCache(gathererFunc(func() ([]*dto.MetricFamily, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		if fail {
+			return nil, fmt.Errorf("Unavailable")
+		}
+		return []*dto.MetricFamily{family("arangodb_client_connections", dto.MetricType_GAUGE)}, nil
+	}), time.Hour, "test")
+
+	// Concurrent gathers result in a single call
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if families, err := cache.Gather(); err != nil || len(families) != 1 {
+				t.Errorf("Gather failed: got %d families, error %v", len(families), err)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("Concurrent gathers failed: got %d calls, expected 1", n)
+	}
+
+	// Fresh results are served from the cache
+	if _, err := cache.Gather(); err != nil {
+		t.Errorf("Gather failed: %v", err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("Cached gather failed: got %d calls, expected 1", n)
+	}
+
+	// Expired results are gathered again, failures are not cached
+	cache.maxAge = 0
+	fail = true
+	for i := 0; i < 2; i++ {
+		if _, err := cache.Gather(); err == nil {
+			t.Errorf("Failing gather %d failed: expected an error", i)
+		}
+	}
+	if n := atomic.LoadInt32(&calls); n != 3 {
+		t.Errorf("Expired gathers failed: got %d calls, expected 3", n)
+	}
+}
+
+// countingCollector is a contextCollector counting its collects.
+type countingCollector struct {
+	desc  *prometheus.Desc
+	calls int32
+}
+
+func (c *countingCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *countingCollector) Collect(ch chan<- prometheus.Metric) {
+	c.CollectContext(context.Background(), ch)
+}
+
+func (c *countingCollector) CollectContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	atomic.AddInt32(&c.calls, 1)
+	ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, 1)
+}
+
+// TestScrapeSource tests that the metrics of a scrape source are only served from the cache when it is enabled.
+func TestScrapeSource(t *testing.T) {
+	tests := []struct {
+		MaxAge time.Duration
+		Calls  int32
+	}{
+		{0, 3},
+		{time.Hour, 1},
+	}
+
+	for i, test := range tests {
+		c := &countingCollector{desc: prometheus.NewDesc("arangodb_up", "Up.", nil, nil)}
+		source := newScrapeSource(c, test.MaxAge)
+		for j := 0; j < 3; j++ {
+			g, err := source.gatherer(context.Background())
+			if err != nil {
+				t.Fatalf("gatherer for test %d failed: %v", i, err)
+			}
+			if _, err := g.Gather(); err != nil {
+				t.Errorf("Gather for test %d failed: %v", i, err)
+			}
+		}
+		if n := atomic.LoadInt32(&c.calls); n != test.Calls {
+			t.Errorf("Scrape source for test %d collected %d times, expected %d", i, n, test.Calls)
+		}
+	}
+}
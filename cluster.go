@@ -0,0 +1,240 @@
+//
+// DISCLAIMER
+//
+// Copyright 2018 ArangoDB GmbH, Cologne, Germany
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Copyright holder is ArangoDB GmbH, Cologne, Germany
+//
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	driver "github.com/arangodb/go-driver"
+	"github.com/arangodb/go-driver/util"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+// ClusterExporter discovers all members of an ArangoDB cluster through a coordinator
+// and collects the statistics of each member, labeled with the identity of that member.
+type ClusterExporter struct {
+	transport http.RoundTripper
+	endpoints *endpointSelector
+	auth      Authentication
+	sslVerify bool
+	timeout   time.Duration
+	mutex     sync.Mutex
+
+	members     map[driver.ServerID]*clusterMember
+	discoveryUp prometheus.Gauge
+	health      *clusterHealthCollector // Set when the cluster health collector is enabled
+}
+
+// clusterMember is a discovered cluster member together with the exporter collecting its statistics.
+type clusterMember struct {
+	endpoint string
+	exporter *Exporter
+}
+
+// NewClusterExporter returns an initialized ClusterExporter for the cluster
+// that the coordinator at the given endpoint belongs to.
+func NewClusterExporter(arangodbEndpoint string, auth Authentication, sslVerify bool, timeout time.Duration) (*ClusterExporter, error) {
+	transport := newDriverTransport(sslVerify, timeout)
+	available := func(ctx context.Context, endpoint string) error {
+		conn, err := newConnClientFactory(endpoint, auth, transport)()
+		if err != nil {
+			return maskAny(err)
+		}
+		return GetServerAvailability(ctx, conn)
+	}
+	c := &ClusterExporter{
+		transport: transport,
+		endpoints: newEndpointSelector(arangodbEndpoint, available, timeout, "discovery", nil),
+		auth:      auth,
+		sslVerify: sslVerify,
+		timeout:   timeout,
+		members:   make(map[driver.ServerID]*clusterMember),
+		discoveryUp: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "exporter_cluster_discovery_up",
+			Help:      "Was the last discovery of cluster members successful.",
+		}),
+	}
+	if isCollectorEnabled(collectorClusterHealth) {
+		c.health = newClusterHealthCollector(nil)
+	}
+	return c, nil
+}
+
+// Describe sends no descriptors, since the set of metrics depends on the
+// discovered cluster members. This makes the ClusterExporter an unchecked collector.
+// It implements prometheus.Collector.
+func (c *ClusterExporter) Describe(ch chan<- *prometheus.Desc) {
+}
+
+// Collect discovers the cluster members and collects the statistics of all of them.
+// It implements prometheus.Collector.
+func (c *ClusterExporter) Collect(ch chan<- prometheus.Metric) {
+	c.CollectContext(context.Background(), ch)
+}
+
+// CollectContext is Collect, giving up on the cluster members when the given context is done.
+// It implements contextCollector.
+func (c *ClusterExporter) CollectContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	exporters, health := c.discover(ctx)
+
+	ch <- c.discoveryUp
+	c.endpoints.Collect(ch)
+	for _, m := range health {
+		ch <- m
+	}
+
+	wg := sync.WaitGroup{}
+	for _, e := range exporters {
+		wg.Add(1)
+		go func(e *Exporter) {
+			defer wg.Done()
+			e.CollectContext(ctx, ch)
+		}(e)
+	}
+	wg.Wait()
+}
+
+// discover updates the list of cluster members and returns the exporters of all known members,
+// together with the health metrics of the members when the cluster health collector is enabled.
+// When discovery fails, the previously discovered members are kept, but no health metrics are returned.
+// The cluster is queried without holding the lock, so concurrent scrapes do not wait for each other.
+func (c *ClusterExporter) discover(ctx context.Context) ([]*Exporter, []prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	var metrics []prometheus.Metric
+	health, err := c.fetchHealth(ctx)
+	if err != nil {
+		c.discoveryUp.Set(0)
+		c.endpoints.failover()
+		log.Errorf("Failed to discover cluster members: %v", err)
+	} else {
+		c.discoveryUp.Set(1)
+		if c.health != nil {
+			metrics = c.health.metrics(health, time.Now())
+		}
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if err == nil {
+		c.updateMembers(health)
+	}
+	result := make([]*Exporter, 0, len(c.members))
+	for _, m := range c.members {
+		result = append(result, m.exporter)
+	}
+	return result, metrics
+}
+
+// fetchHealth fetches the health of all members from a coordinator of the cluster.
+func (c *ClusterExporter) fetchHealth(ctx context.Context) (driver.ClusterHealth, error) {
+	conn, err := newConnClientFactory(c.endpoints.get(ctx), c.auth, c.transport)()
+	if err != nil {
+		return driver.ClusterHealth{}, maskAny(err)
+	}
+
+	// Only coordinators know the endpoints of the cluster
+	if _, err := GetClusterEndpoints(ctx, conn); err != nil {
+		return driver.ClusterHealth{}, maskAny(fmt.Errorf("Endpoint is not a cluster coordinator: %v", err))
+	}
+
+	health, err := GetClusterHealth(ctx, conn)
+	if err != nil {
+		return driver.ClusterHealth{}, maskAny(err)
+	}
+	return health, nil
+}
+
+// updateMembers updates the list of members according to the given cluster health.
+// Members that have left the cluster are removed, so their series are no longer exported.
+// The idle connections of removed exporters are closed.
+// The caller must hold the mutex.
+func (c *ClusterExporter) updateMembers(health driver.ClusterHealth) {
+	for id, m := range c.members {
+		if _, found := health.Health[id]; !found {
+			log.Infof("Cluster member %s has left the cluster", id)
+			m.exporter.CloseIdleConnections()
+			delete(c.members, id)
+		}
+	}
+
+	for id, h := range health.Health {
+		endpoint := memberEndpoint(h)
+		if endpoint == "" {
+			continue
+		}
+		m, found := c.members[id]
+		if found && m.endpoint == endpoint {
+			continue
+		}
+		if found {
+			m.exporter.CloseIdleConnections()
+		}
+		log.Infof("Discovered cluster member %s (%s) at %s", id, h.Role, endpoint)
+		labels := prometheus.Labels{
+			"server_id":  string(id),
+			"role":       roleLabel(string(h.Role)),
+			"short_name": h.ShortName,
+		}
+		c.members[id] = &clusterMember{
+			endpoint: endpoint,
+			exporter: newExporter(endpoint, c.auth, c.sslVerify, c.timeout, labels, nil),
+		}
+	}
+}
+
+// memberEndpoint returns the HTTP(S) endpoint used to reach the given cluster member.
+func memberEndpoint(h driver.ServerHealth) string {
+	endpoint := h.Endpoint
+	if h.AdvertisedEndpoint != nil && *h.AdvertisedEndpoint != "" {
+		endpoint = *h.AdvertisedEndpoint
+	}
+	if endpoint == "" {
+		return ""
+	}
+	return util.FixupEndpointURLScheme(endpoint)
+}
+
+// roleLabel returns the value of the role label for the given server role,
+// as reported by either _admin/cluster/health or _admin/server/role.
+func roleLabel(role string) string {
+	switch strings.ToUpper(role) {
+	case "DBSERVER", "PRIMARY":
+		return "dbserver"
+	case "COORDINATOR":
+		return "coordinator"
+	case "AGENT":
+		return "agent"
+	case "SINGLE":
+		return "single"
+	default:
+		return strings.ToLower(role)
+	}
+}
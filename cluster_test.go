@@ -0,0 +1,149 @@
+//
+// DISCLAIMER
+//
+// Copyright 2018 ArangoDB GmbH, Cologne, Germany
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Copyright holder is ArangoDB GmbH, Cologne, Germany
+//
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// newMemberServer returns a server with the statistics of a cluster member, counting the statistics requests.
+func newMemberServer(statistics *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/_admin/statistics-description":
+			w.Write([]byte(`{"groups":[{"group":"client","name":"Client","description":"Client"}],"figures":[
+				{"group":"client","identifier":"httpConnections","name":"Client Connections","description":"Connections","type":"current"}]}`))
+		case "/_admin/statistics":
+			atomic.AddInt32(statistics, 1)
+			w.Write([]byte(`{"client":{"httpConnections":3}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+// TestClusterExporter tests the discovery of cluster members, including members that leave the cluster
+// and members whose endpoint changes between scrapes.
+func TestClusterExporter(t *testing.T) {
+	var statsA, statsB, statsC int32
+	memberA, memberB, memberC := newMemberServer(&statsA), newMemberServer(&statsB), newMemberServer(&statsC)
+	defer memberA.Close()
+	defer memberB.Close()
+	defer memberC.Close()
+	tcp := func(s *httptest.Server) string {
+		return strings.Replace(s.URL, "http://", "tcp://", 1)
+	}
+
+	var health atomic.Value
+	coordinator := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/_admin/server/availability":
+			w.Write([]byte(`{"mode":"default"}`))
+		case "/_api/cluster/endpoints":
+			w.Write([]byte(`{"endpoints":[]}`))
+		case "/_admin/cluster/health":
+			w.Write([]byte(health.Load().(string)))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer coordinator.Close()
+
+	member := func(id, role, shortName string, s *httptest.Server) string {
+		return fmt.Sprintf(`"%s":{"Role":"%s","ShortName":"%s","Endpoint":"%s"}`, id, role, shortName, tcp(s))
+	}
+	tests := []struct {
+		Health     string
+		Expected   []string
+		Unexpected []string
+		Statistics [3]int32
+	}{
+		{
+			member("PRMR-1", "DBServer", "DBServer0001", memberA) + "," + member("CRDN-1", "Coordinator", "Coordinator0001", memberB),
+			[]string{`role="dbserver",server_id="PRMR-1",short_name="DBServer0001"`, `role="coordinator",server_id="CRDN-1",short_name="Coordinator0001"`},
+			nil,
+			[3]int32{1, 1, 0},
+		},
+		// CRDN-1 has left the cluster, PRMR-1 moved to another endpoint
+		{
+			member("PRMR-1", "DBServer", "DBServer0001", memberC),
+			[]string{`role="dbserver",server_id="PRMR-1",short_name="DBServer0001"`},
+			[]string{`server_id="CRDN-1"`},
+			[3]int32{1, 1, 1},
+		},
+	}
+
+	c, err := NewClusterExporter(coordinator.URL, func() (string, error) { return "", nil }, false, time.Second)
+	if err != nil {
+		t.Fatalf("NewClusterExporter failed: %v", err)
+	}
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(c)
+
+	for i, test := range tests {
+		health.Store(`{"ClusterId":"c","Health":{` + test.Health + `}}`)
+		families, err := registry.Gather()
+		if err != nil {
+			t.Errorf("Gather for test %d failed: %v", i, err)
+		}
+		var series []string
+		for _, mf := range families {
+			if mf.GetName() != "arangodb_client_client_connections" {
+				continue
+			}
+			for _, m := range mf.GetMetric() {
+				var labels []string
+				for _, l := range m.GetLabel() {
+					labels = append(labels, fmt.Sprintf("%s=%q", l.GetName(), l.GetValue()))
+				}
+				series = append(series, strings.Join(labels, ","))
+			}
+		}
+		all := strings.Join(series, "\n")
+		if len(series) != len(test.Expected) {
+			t.Errorf("Gather for test %d failed: got %d series, expected %d:\n%s", i, len(series), len(test.Expected), all)
+		}
+		for _, expected := range test.Expected {
+			if !strings.Contains(all, expected) {
+				t.Errorf("Gather for test %d failed: expected %s in\n%s", i, expected, all)
+			}
+		}
+		for _, unexpected := range test.Unexpected {
+			if strings.Contains(all, unexpected) {
+				t.Errorf("Gather for test %d failed: unexpected %s in\n%s", i, unexpected, all)
+			}
+		}
+		stats := [3]int32{atomic.LoadInt32(&statsA), atomic.LoadInt32(&statsB), atomic.LoadInt32(&statsC)}
+		if stats != test.Statistics {
+			t.Errorf("Gather for test %d failed: got statistics requests %v, expected %v", i, stats, test.Statistics)
+		}
+	}
+}
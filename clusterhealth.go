@@ -0,0 +1,122 @@
+//
+// DISCLAIMER
+//
+// Copyright 2018 ArangoDB GmbH, Cologne, Germany
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Copyright holder is ArangoDB GmbH, Cologne, Germany
+//
+
+package main
+
+import (
+	"context"
+	"time"
+
+	driver "github.com/arangodb/go-driver"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+var (
+	// memberStatuses lists the values of the status label of arangodb_cluster_member_status.
+	memberStatuses = []driver.ServerStatus{driver.ServerStatusGood, driver.ServerStatusBad, driver.ServerStatusFailed}
+	// memberSyncStatuses lists the values of the sync_status label of arangodb_cluster_member_sync_status.
+	memberSyncStatuses = []driver.ServerSyncStatus{
+		driver.ServerSyncStatusUnknown,
+		driver.ServerSyncStatusUndefined,
+		driver.ServerSyncStatusStartup,
+		driver.ServerSyncStatusStopping,
+		driver.ServerSyncStatusStopped,
+		driver.ServerSyncStatusServing,
+		driver.ServerSyncStatusShutdown,
+	}
+)
+
+// clusterHealthCollector exports the health of all cluster members, as seen by a coordinator.
+type clusterHealthCollector struct {
+	status, canBeDeleted, heartbeatAge, syncStatus, versionInfo *prometheus.Desc
+}
+
+// newClusterHealthCollector returns a clusterHealthCollector adding the given labels to its metrics.
+// The metrics are labeled with the server_id, role & short_name of the member, so the given
+// labels must not contain these.
+func newClusterHealthCollector(labels prometheus.Labels) *clusterHealthCollector {
+	member := []string{"server_id", "role", "short_name"}
+	return &clusterHealthCollector{
+		status: prometheus.NewDesc(prometheus.BuildFQName(namespace, "cluster", "member_status"),
+			"Health status of the cluster member, one series per status.", append(member, "status"), labels),
+		canBeDeleted: prometheus.NewDesc(prometheus.BuildFQName(namespace, "cluster", "member_can_be_deleted"),
+			"Can the cluster member be removed from the cluster.", member, labels),
+		heartbeatAge: prometheus.NewDesc(prometheus.BuildFQName(namespace, "cluster", "member_heartbeat_age_seconds"),
+			"Time since the last heartbeat of the cluster member was acknowledged by the agency.", member, labels),
+		syncStatus: prometheus.NewDesc(prometheus.BuildFQName(namespace, "cluster", "member_sync_status"),
+			"Sync status of the cluster member, one series per status.", append(member, "sync_status"), labels),
+		versionInfo: prometheus.NewDesc(prometheus.BuildFQName(namespace, "cluster", "member_version_info"),
+			"Version of the cluster member.", append(member, "version"), labels),
+	}
+}
+
+// collect implements collector.
+func (c *clusterHealthCollector) collect(ctx context.Context, s *scrape) ([]prometheus.Metric, error) {
+	conn, err := s.connection()
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	health, err := GetClusterHealth(ctx, conn)
+	if err != nil {
+		log.Errorf("Failed to fetch cluster health: %v", err)
+		return nil, maskAny(err)
+	}
+	return c.metrics(health, time.Now()), nil
+}
+
+// metrics returns the metrics for the given cluster health, with heartbeat ages relative to the given time.
+// Fields that are not reported for a member, e.g. the sync status of agents, are left out.
+func (c *clusterHealthCollector) metrics(health driver.ClusterHealth, now time.Time) []prometheus.Metric {
+	var result []prometheus.Metric
+	for id, h := range health.Health {
+		member := []string{string(id), roleLabel(string(h.Role)), h.ShortName}
+		for _, status := range memberStatuses {
+			result = append(result, prometheus.MustNewConstMetric(c.status, prometheus.GaugeValue,
+				boolValue(h.Status == status), append(member, string(status))...))
+		}
+		result = append(result, prometheus.MustNewConstMetric(c.canBeDeleted, prometheus.GaugeValue, boolValue(h.CanBeDeleted), member...))
+		if !h.LastHeartbeatAcked.IsZero() {
+			age := now.Sub(h.LastHeartbeatAcked).Seconds()
+			if age < 0 {
+				age = 0
+			}
+			result = append(result, prometheus.MustNewConstMetric(c.heartbeatAge, prometheus.GaugeValue, age, member...))
+		}
+		if h.SyncStatus != "" {
+			for _, status := range memberSyncStatuses {
+				result = append(result, prometheus.MustNewConstMetric(c.syncStatus, prometheus.GaugeValue,
+					boolValue(h.SyncStatus == status), append(member, string(status))...))
+			}
+		}
+		if h.Version != "" {
+			result = append(result, prometheus.MustNewConstMetric(c.versionInfo, prometheus.GaugeValue, 1, append(member, string(h.Version))...))
+		}
+	}
+	return result
+}
+
+// boolValue returns 1 for true and 0 for false.
+func boolValue(value bool) float64 {
+	if value {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,138 @@
+//
+// DISCLAIMER
+//
+// Copyright 2018 ArangoDB GmbH, Cologne, Germany
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Copyright holder is ArangoDB GmbH, Cologne, Germany
+//
+
+package main
+
+import (
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	driver "github.com/arangodb/go-driver"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// TestClusterHealthMetrics tests the metrics created from the health of cluster members.
+func TestClusterHealthMetrics(t *testing.T) {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		Health   driver.ServerHealth
+		Expected map[string]float64
+	}{
+		{
+			driver.ServerHealth{Role: "DBServer", ShortName: "DBServer0001", Status: driver.ServerStatusFailed, CanBeDeleted: true,
+				LastHeartbeatAcked: now.Add(-time.Minute), SyncStatus: driver.ServerSyncStatusServing, Version: "3.5.3"},
+			map[string]float64{
+				"arangodb_cluster_member_status{status=GOOD}":                0,
+				"arangodb_cluster_member_status{status=BAD}":                 0,
+				"arangodb_cluster_member_status{status=FAILED}":              1,
+				"arangodb_cluster_member_can_be_deleted{}":                   1,
+				"arangodb_cluster_member_heartbeat_age_seconds{}":            60,
+				"arangodb_cluster_member_sync_status{sync_status=SERVING}":   1,
+				"arangodb_cluster_member_sync_status{sync_status=STARTUP}":   0,
+				"arangodb_cluster_member_sync_status{sync_status=STOPPED}":   0,
+				"arangodb_cluster_member_sync_status{sync_status=STOPPING}":  0,
+				"arangodb_cluster_member_sync_status{sync_status=SHUTDOWN}":  0,
+				"arangodb_cluster_member_sync_status{sync_status=UNDEFINED}": 0,
+				"arangodb_cluster_member_sync_status{sync_status=UNKNOWN}":   0,
+				"arangodb_cluster_member_version_info{version=3.5.3}":        1,
+			},
+		},
+		{
+			// Agents report neither heartbeat, sync status nor version
+			driver.ServerHealth{Role: "Agent", Status: driver.ServerStatusGood},
+			map[string]float64{
+				"arangodb_cluster_member_status{status=GOOD}":   1,
+				"arangodb_cluster_member_status{status=BAD}":    0,
+				"arangodb_cluster_member_status{status=FAILED}": 0,
+				"arangodb_cluster_member_can_be_deleted{}":      0,
+			},
+		},
+	}
+
+	c := newClusterHealthCollector(nil)
+	for i, test := range tests {
+		health := driver.ClusterHealth{Health: map[driver.ServerID]driver.ServerHealth{"PRMR-1": test.Health}}
+		values := make(map[string]float64)
+		for _, m := range c.metrics(health, now) {
+			var pb dto.Metric
+			if err := m.Write(&pb); err != nil {
+				t.Fatalf("Write for test %d failed: %v", i, err)
+			}
+			var labels []string
+			for _, l := range pb.GetLabel() {
+				switch l.GetName() {
+				case "server_id":
+					if l.GetValue() != "PRMR-1" {
+						t.Errorf("Metric for test %d has unexpected server_id %s", i, l.GetValue())
+					}
+				case "role":
+					if l.GetValue() != roleLabel(string(test.Health.Role)) {
+						t.Errorf("Metric for test %d has unexpected role %s", i, l.GetValue())
+					}
+				case "short_name":
+				default:
+					labels = append(labels, l.GetName()+"="+l.GetValue())
+				}
+			}
+			sort.Strings(labels)
+			name := strings.SplitN(strings.TrimPrefix(m.Desc().String(), `Desc{fqName: "`), `"`, 2)[0]
+			values[name+"{"+strings.Join(labels, ",")+"}"] = pb.GetGauge().GetValue()
+		}
+		if len(values) != len(test.Expected) {
+			t.Errorf("Metrics for test %d: got %d series, expected %d: %v", i, len(values), len(test.Expected), values)
+		}
+		for key, value := range test.Expected {
+			if v, found := values[key]; !found || v != value {
+				t.Errorf("Metrics for test %d returns unexpected %s: got %v (found %v), expected %v", i, key, v, found, value)
+			}
+		}
+	}
+}
+
+// TestClusterHealthCollectorMembers tests that exporters of discovered cluster members leave out the cluster health collector.
+func TestClusterHealthCollectorMembers(t *testing.T) {
+	defer func(enabled bool) {
+		*collectorEnabled[collectorClusterHealth] = enabled
+	}(*collectorEnabled[collectorClusterHealth])
+	*collectorEnabled[collectorClusterHealth] = true
+
+	tests := []struct {
+		Labels   prometheus.Labels
+		Expected bool
+	}{
+		{nil, true},
+		{prometheus.Labels{"server_id": "PRMR-1", "role": "dbserver", "short_name": "DBServer0001"}, false},
+	}
+	for i, test := range tests {
+		e := newExporter("http://localhost:8529", func() (string, error) { return "", nil }, false, time.Second, test.Labels, nil)
+		found := false
+		for _, c := range e.collectors {
+			if c.name == collectorClusterHealth {
+				found = true
+			}
+		}
+		if found != test.Expected {
+			t.Errorf("Exporter for test %d has cluster health collector %v, expected %v", i, found, test.Expected)
+		}
+	}
+}
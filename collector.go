@@ -0,0 +1,232 @@
+//
+// DISCLAIMER
+//
+// Copyright 2018 ArangoDB GmbH, Cologne, Germany
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Copyright holder is ArangoDB GmbH, Cologne, Germany
+//
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	driver "github.com/arangodb/go-driver"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+const (
+	collectorActiveFailover = "activefailover" // Role & replication lag in Active Failover
+	collectorClusterHealth  = "clusterhealth"  // Health of all cluster members, only on coordinators
+	collectorStatistics     = "statistics"     // Figures listed in the statistics description
+	collectorServer         = "server"         // Server & system statistics
+)
+
+// collectorNames lists the names of all collectors, in the order they are run.
+// The Active Failover collector runs first, so the others can be skipped on followers.
+var collectorNames = []string{collectorActiveFailover, collectorStatistics, collectorServer, collectorClusterHealth}
+
+// collectorEnabled holds whether the collectors are enabled, by name.
+var collectorEnabled = map[string]*bool{
+	collectorActiveFailover: boolPtr(false),
+	collectorStatistics:     boolPtr(true),
+	collectorServer:         boolPtr(true),
+	collectorClusterHealth:  boolPtr(false),
+}
+
+// boolPtr returns a pointer to the given value.
+func boolPtr(value bool) *bool {
+	return &value
+}
+
+// isCollectorEnabled returns true if the collector with the given name is enabled.
+func isCollectorEnabled(name string) bool {
+	enabled, found := collectorEnabled[name]
+	return found && *enabled
+}
+
+// collectorTimeouts holds the timeouts of collectors by name.
+// Collectors that are not listed use the timeout of the exporter.
+var collectorTimeouts = map[string]time.Duration{}
+
+// parseCollectorTimeouts parses the given name=duration pairs into collector timeouts.
+func parseCollectorTimeouts(pairs []string) (map[string]time.Duration, error) {
+	result := make(map[string]time.Duration, len(pairs))
+	for _, pair := range pairs {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Invalid collector timeout '%s', expected name=duration", pair)
+		}
+		if !isValidCollector(parts[0]) {
+			return nil, fmt.Errorf("Unknown collector '%s', expected one of %s", parts[0], strings.Join(collectorNames, ", "))
+		}
+		timeout, err := time.ParseDuration(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid timeout of collector '%s': %v", parts[0], err)
+		}
+		result[parts[0]] = timeout
+	}
+	return result, nil
+}
+
+// isValidCollector returns true if a collector with the given name exists.
+func isValidCollector(name string) bool {
+	for _, n := range collectorNames {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// collector creates metrics from a single source of data of an ArangoDB server.
+type collector interface {
+	// collect returns the metrics of the collector. The metrics returned together
+	// with an error are exposed as partial result.
+	collect(ctx context.Context, s *scrape) ([]prometheus.Metric, error)
+}
+
+// namedCollector is a collector of the exporter, together with its name & timeout.
+type namedCollector struct {
+	name      string
+	timeout   time.Duration
+	collector collector
+}
+
+// scrape holds the data of a single scrape of the exporter, which is shared by its collectors.
+// Collectors are run one after another, so no locking is needed.
+type scrape struct {
+	factory connClientFactory
+
+	conn       driver.Connection
+	connErr    error
+	stats      Statistics
+	statsErr   error
+	fetched    bool
+	statsRetry bool // Set when fetching the statistics failed because the collector gave up, not the server
+	follower   bool // Set by the Active Failover collector when the server is a follower
+}
+
+// connection returns the connection used for this scrape.
+func (s *scrape) connection() (driver.Connection, error) {
+	if s.conn == nil && s.connErr == nil {
+		s.conn, s.connErr = s.factory()
+		if s.connErr != nil {
+			log.Errorf("Failed to create client: %v", s.connErr)
+		}
+	}
+	return s.conn, s.connErr
+}
+
+// statistics returns the statistics of the server, which are fetched once per scrape,
+// within the context of the first collector that needs them. When that collector gave up
+// because its timeout expired, the next collector fetches them again within its own timeout.
+func (s *scrape) statistics(ctx context.Context) (Statistics, error) {
+	if !s.fetched || s.statsRetry {
+		s.fetched = true
+		s.statsRetry = false
+		conn, err := s.connection()
+		if err != nil {
+			s.statsErr = maskAny(err)
+		} else if s.stats, err = GetStatistics(ctx, conn); err != nil {
+			log.Errorf("Failed to fetch statistics: %v", err)
+			s.statsErr = maskAny(err)
+			s.statsRetry = ctx.Err() != nil
+		} else {
+			s.statsErr = nil
+		}
+	}
+	return s.stats, s.statsErr
+}
+
+// statisticsCollector exports the figures listed in the statistics description.
+type statisticsCollector struct {
+	descriptions *descriptionCache
+	labels       prometheus.Labels
+}
+
+// collect implements collector.
+func (c *statisticsCollector) collect(ctx context.Context, s *scrape) ([]prometheus.Metric, error) {
+	stats, err := s.statistics(ctx)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	conn, err := s.connection()
+	if err != nil {
+		return nil, maskAny(err)
+	}
+
+	// Gather descriptions, which are cached between scrapes
+	descr, err := c.descriptions.get(ctx, conn, stats)
+	if err != nil {
+		log.Errorf("Failed to fetch statistic descriptions: %v", err)
+		return nil, maskAny(err)
+	}
+
+	// Now parse the statistics & create the metrics
+	groups := make(map[string]StatisticGroup)
+	for _, g := range descr.Groups {
+		groups[g.Group] = g
+	}
+	var result []prometheus.Metric
+	seen := make(map[string]struct{})
+	for _, f := range descr.Figures {
+		group, found := groups[f.Group]
+		if !found {
+			// Skip figure with unknown group
+			continue
+		}
+		groupStats := stats.GetGroup(f.Group)
+		if groupStats == nil {
+			// Skip no group is found in the statistics
+			continue
+		}
+		key := metricKey(group, f, "")
+		if _, found := seen[key]; found {
+			// Skip figure with the same name as an earlier figure
+			continue
+		}
+		seen[key] = struct{}{}
+		metrics, err := figureMetrics(group, f, groupStats, c.labels)
+		if err != nil {
+			log.Errorf("Failed to create metrics for %s: %v", key, err)
+			continue
+		}
+		result = append(result, metrics...)
+	}
+	return result, nil
+}
+
+// serverCollector exports the server & system statistics that are not listed in the statistics description.
+type serverCollector struct {
+	descriptions *descriptionCache
+	labels       prometheus.Labels
+}
+
+// collect implements collector.
+func (c *serverCollector) collect(ctx context.Context, s *scrape) ([]prometheus.Metric, error) {
+	stats, err := s.statistics(ctx)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	// Only use a description that is already known, the statistics collector fetches it.
+	// Without description, all server statistics are exported.
+	descr, _ := c.descriptions.cached()
+	return serverStatisticsMetrics(stats, descr, c.labels), nil
+}
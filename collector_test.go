@@ -0,0 +1,158 @@
+//
+// DISCLAIMER
+//
+// Copyright 2018 ArangoDB GmbH, Cologne, Germany
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Copyright holder is ArangoDB GmbH, Cologne, Germany
+//
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// TestParseCollectorTimeouts tests parsing of collector timeouts for various inputs.
+func TestParseCollectorTimeouts(t *testing.T) {
+	tests := []struct {
+		Input    []string
+		Expected map[string]time.Duration
+		Error    bool
+	}{
+		{nil, map[string]time.Duration{}, false},
+		{[]string{"statistics=5s", "server=1m"}, map[string]time.Duration{"statistics": 5 * time.Second, "server": time.Minute}, false},
+		{[]string{"statistics"}, nil, true},
+		{[]string{"unknown=5s"}, nil, true},
+		{[]string{"server=soon"}, nil, true},
+	}
+
+	for i, test := range tests {
+		result, err := parseCollectorTimeouts(test.Input)
+		if test.Error {
+			if err == nil {
+				t.Errorf("parseCollectorTimeouts for test %d failed: expected an error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseCollectorTimeouts for test %d failed: %v", i, err)
+		} else if len(result) != len(test.Expected) {
+			t.Errorf("parseCollectorTimeouts for test %d failed: got %v, expected %v", i, result, test.Expected)
+		} else {
+			for name, timeout := range test.Expected {
+				if result[name] != timeout {
+					t.Errorf("parseCollectorTimeouts for test %d failed: got %v, expected %v", i, result, test.Expected)
+				}
+			}
+		}
+	}
+}
+
+// TestExporterPartialResult tests that the exporter returns the metrics of the collectors
+// that succeed when the statistics description cannot be fetched.
+func TestExporterPartialResult(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/_admin/statistics":
+			w.Write([]byte(`{"server":{"uptime":12.5}}`))
+		default:
+			http.Error(w, `{"error":true,"code":503}`, http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	e, _ := NewExporter(server.URL, func() (string, error) { return "", nil }, false, time.Second)
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(e)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather failed: %v", err)
+	}
+
+	values := make(map[string]float64)
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			name := mf.GetName()
+			for _, lp := range m.GetLabel() {
+				name += "{" + lp.GetName() + "=" + lp.GetValue() + "}"
+			}
+			values[name] = m.GetGauge().GetValue() + m.GetCounter().GetValue()
+		}
+	}
+	expected := map[string]float64{
+		"arangodb_up":                    1,
+		"arangodb_server_uptime_seconds": 12.5,
+		"arangodb_exporter_collector_success{collector=statistics}": 0,
+		"arangodb_exporter_collector_success{collector=server}":     1,
+		"arangodb_exporter_failed_scrapes":                          1,
+	}
+	for name, value := range expected {
+		if v, found := values[name]; !found || v != value {
+			t.Errorf("Gather returns unexpected %s: got %v (found %v), expected %v", name, v, found, value)
+		}
+	}
+	if _, found := values["arangodb_exporter_collector_duration_seconds{collector=statistics}"]; !found {
+		t.Errorf("Gather does not return the duration of the statistics collector")
+	}
+}
+
+// TestExporterCollectorTimeout tests that the server collector fetches the statistics again
+// within its own timeout, when the statistics collector timed out fetching them.
+func TestExporterCollectorTimeout(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/_admin/statistics":
+			if atomic.AddInt32(&requests, 1) == 1 {
+				time.Sleep(200 * time.Millisecond)
+			}
+			w.Write([]byte(`{"server":{"uptime":12.5}}`))
+		default:
+			http.Error(w, `{"error":true,"code":503}`, http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	collectorTimeouts = map[string]time.Duration{collectorStatistics: 50 * time.Millisecond}
+	defer func() { collectorTimeouts = map[string]time.Duration{} }()
+	e, _ := NewExporter(server.URL, func() (string, error) { return "", nil }, false, time.Second)
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(e)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather failed: %v", err)
+	}
+
+	success := make(map[string]float64)
+	for _, mf := range families {
+		if mf.GetName() != "arangodb_exporter_collector_success" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			success[m.GetLabel()[0].GetValue()] = m.GetGauge().GetValue()
+		}
+	}
+	if success[collectorStatistics] != 0 || success[collectorServer] != 1 {
+		t.Errorf("Gather returns unexpected collector success: got %v, expected statistics 0 & server 1", success)
+	}
+}
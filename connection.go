@@ -25,6 +25,8 @@ package main
 import (
 	"io/ioutil"
 	"strings"
+	"sync"
+	"time"
 )
 
 type Authentication func() (string, error)
@@ -49,3 +51,33 @@ func newAuthentication() Authentication {
 		return "", nil
 	}
 }
+
+// newCachedAuthentication returns an authentication that caches the JWT of the given
+// authentication, requesting a new one only after the given refresh interval.
+// This decouples reading & signing the JWT from the requests made to ArangoDB.
+func newCachedAuthentication(auth Authentication, refresh time.Duration) Authentication {
+	if refresh <= 0 {
+		return auth
+	}
+
+	var mutex sync.Mutex
+	var jwt string
+	var expires time.Time
+
+	return func() (string, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if time.Now().Before(expires) {
+			return jwt, nil
+		}
+
+		token, err := auth()
+		if err != nil {
+			return "", err
+		}
+		jwt = token
+		expires = time.Now().Add(refresh)
+		return jwt, nil
+	}
+}
@@ -0,0 +1,75 @@
+//
+// DISCLAIMER
+//
+// Copyright 2018 ArangoDB GmbH, Cologne, Germany
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Copyright holder is ArangoDB GmbH, Cologne, Germany
+//
+
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// TestCachedAuthentication tests that the JWT is reused within the refresh interval and generated again after it.
+func TestCachedAuthentication(t *testing.T) {
+	calls := 0
+	failing := false
+	auth := func() (string, error) {
+		if failing {
+			return "", fmt.Errorf("JWT secret is not available")
+		}
+		calls++
+		return fmt.Sprintf("jwt-%d", calls), nil
+	}
+	cached := newCachedAuthentication(auth, 50*time.Millisecond)
+
+	tests := []struct {
+		Wait     time.Duration
+		Failing  bool
+		Expected string
+	}{
+		{0, false, "jwt-1"},                     // Generated
+		{10 * time.Millisecond, false, "jwt-1"}, // Reused within the refresh interval
+		{60 * time.Millisecond, false, "jwt-2"}, // Generated again after the refresh interval
+		{0, false, "jwt-2"},                     // Reused
+		{60 * time.Millisecond, true, ""},       // Generating fails, the expired JWT is not used
+		{0, false, "jwt-3"},                     // Generated again after the failure
+	}
+
+	for i, test := range tests {
+		time.Sleep(test.Wait)
+		failing = test.Failing
+		jwt, err := cached()
+		if test.Failing {
+			if err == nil {
+				t.Errorf("Authentication for test %d succeeded, expected an error", i)
+			}
+		} else if err != nil {
+			t.Errorf("Authentication for test %d failed: %v", i, err)
+		} else if jwt != test.Expected {
+			t.Errorf("Authentication for test %d failed: got %s, expected %s", i, jwt, test.Expected)
+		}
+	}
+
+	uncached := newCachedAuthentication(auth, 0)
+	uncached()
+	if jwt, _ := uncached(); jwt != "jwt-5" {
+		t.Errorf("Authentication without refresh interval failed: got %s, expected jwt-5", jwt)
+	}
+}
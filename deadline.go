@@ -0,0 +1,109 @@
+//
+// DISCLAIMER
+//
+// Copyright 2018 ArangoDB GmbH, Cologne, Germany
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Copyright holder is ArangoDB GmbH, Cologne, Germany
+//
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/log"
+)
+
+const (
+	// scrapeTimeoutHeader is the header in which Prometheus sends the timeout of a scrape.
+	scrapeTimeoutHeader = "X-Prometheus-Scrape-Timeout-Seconds"
+)
+
+// scrapeTimeoutOffset is subtracted from the scrape timeout sent by Prometheus,
+// leaving time to respond before Prometheus gives up.
+var scrapeTimeoutOffset = 500 * time.Millisecond
+
+// scrapeContext returns the context for requests to ArangoDB made to serve the given scrape.
+// When the scraper sends its timeout, the context expires before that timeout.
+func scrapeContext(req *http.Request) (context.Context, context.CancelFunc) {
+	if value := req.Header.Get(scrapeTimeoutHeader); value != "" {
+		seconds, err := strconv.ParseFloat(value, 64)
+		if err != nil || seconds <= 0 {
+			log.Debugf("Ignoring invalid %s header '%s'", scrapeTimeoutHeader, value)
+		} else {
+			timeout := time.Duration(seconds * float64(time.Second))
+			// Without room for the offset, use the entire timeout
+			if timeout > scrapeTimeoutOffset {
+				timeout -= scrapeTimeoutOffset
+			}
+			return context.WithTimeout(req.Context(), timeout)
+		}
+	}
+	return context.WithCancel(req.Context())
+}
+
+// contextCollector is a prometheus.Collector that can collect its metrics within a given context.
+type contextCollector interface {
+	prometheus.Collector
+	// CollectContext collects the metrics like Collect, giving up on ArangoDB when the context is done.
+	CollectContext(ctx context.Context, ch chan<- prometheus.Metric)
+}
+
+// scrapeCollector collects the metrics of a contextCollector within the context of a single scrape.
+type scrapeCollector struct {
+	collector contextCollector
+	ctx       context.Context
+}
+
+// Describe implements prometheus.Collector.
+func (c scrapeCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.collector.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (c scrapeCollector) Collect(ch chan<- prometheus.Metric) {
+	c.collector.CollectContext(c.ctx, ch)
+}
+
+// scrapeGatherer returns a gatherer that collects the metrics of the given collector within the given context.
+func scrapeGatherer(ctx context.Context, c contextCollector) (prometheus.Gatherer, error) {
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(scrapeCollector{collector: c, ctx: ctx}); err != nil {
+		return nil, maskAny(err)
+	}
+	return registry, nil
+}
+
+// newScrapeHandler returns a handler serving the metrics of the given source, followed by those of the
+// given gatherers. Unless served from the cache, the metrics of the source are collected within the context of the scrape.
+func newScrapeHandler(source *scrapeSource, gatherers ...prometheus.Gatherer) http.Handler {
+	opts := promhttp.HandlerOpts{ErrorHandling: promhttp.ContinueOnError}
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		ctx, cancel := scrapeContext(req)
+		defer cancel()
+
+		g, err := source.gatherer(ctx)
+		if err != nil {
+			http.Error(resp, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		promhttp.HandlerFor(append(prometheus.Gatherers{g}, gatherers...), opts).ServeHTTP(resp, req)
+	})
+}
@@ -0,0 +1,88 @@
+//
+// DISCLAIMER
+//
+// Copyright 2018 ArangoDB GmbH, Cologne, Germany
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Copyright holder is ArangoDB GmbH, Cologne, Germany
+//
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestScrapeContext tests the deadline derived from the scrape timeout header for various inputs.
+func TestScrapeContext(t *testing.T) {
+	tests := []struct {
+		Header   string
+		Deadline bool
+		Timeout  time.Duration
+	}{
+		{"", false, 0},
+		{"10", true, 9500 * time.Millisecond},
+		{"2.5", true, 2 * time.Second},
+		{"0.2", true, 200 * time.Millisecond},
+		{"0", false, 0},
+		{"soon", false, 0},
+	}
+
+	for i, test := range tests {
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		if test.Header != "" {
+			req.Header.Set(scrapeTimeoutHeader, test.Header)
+		}
+		ctx, cancel := scrapeContext(req)
+		start := time.Now()
+		deadline, found := ctx.Deadline()
+		cancel()
+		if found != test.Deadline {
+			t.Errorf("scrapeContext for test %d failed: got deadline %v, expected %v", i, found, test.Deadline)
+		} else if found {
+			if timeout := deadline.Sub(start); timeout > test.Timeout || timeout < test.Timeout-time.Second {
+				t.Errorf("scrapeContext for test %d failed: got timeout %s, expected %s", i, timeout, test.Timeout)
+			}
+		}
+	}
+}
+
+// TestPassthruScrapeTimeout tests that passthru responds before the scrape timeout when ArangoDB is slow.
+func TestPassthruScrapeTimeout(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-time.After(10 * time.Second):
+		}
+	}))
+	defer upstream.Close()
+	defer close(release)
+
+	p := newPassthru(upstream.URL, func() (string, error) { return "", nil }, false, time.Minute, PassthruConfig{MetricsAPI: MetricsAPIV1})
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	req.Header.Set(scrapeTimeoutHeader, "1")
+	rec := httptest.NewRecorder()
+	start := time.Now()
+	p.ServeHTTP(rec, req)
+	if duration := time.Since(start); duration > 900*time.Millisecond {
+		t.Errorf("ServeHTTP did not honor the scrape timeout: took %s", duration)
+	}
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("ServeHTTP failed: got status %d, expected %d", rec.Code, http.StatusBadGateway)
+	}
+}
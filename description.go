@@ -0,0 +1,160 @@
+//
+// DISCLAIMER
+//
+// Copyright 2018 ArangoDB GmbH, Cologne, Germany
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Copyright holder is ArangoDB GmbH, Cologne, Germany
+//
+
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	driver "github.com/arangodb/go-driver"
+	"github.com/prometheus/common/log"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// identityCheckInterval is the interval at which the version & ID of the server are compared
+	// with those of the server the statistics description was fetched from.
+	identityCheckInterval = time.Minute
+)
+
+// descriptionTTL is the time after which the statistics description is fetched again,
+// even when the server does not seem to have changed.
+var descriptionTTL = time.Hour
+
+// descriptionCache caches the statistics description of a server, which only changes
+// when the server is upgraded. The description is fetched again after the TTL, or when
+// the version or ID of the server changed. These are checked at an interval, and right away
+// when the uptime of the server shows that it restarted.
+// Requests to the server are made without holding the lock, so concurrent scrapes are not blocked.
+type descriptionCache struct {
+	ttl           time.Duration
+	checkInterval time.Duration
+	group         singleflight.Group
+
+	mutex    sync.Mutex
+	descr    *StatisticsDescription
+	fetched  time.Time
+	checked  time.Time
+	version  driver.Version
+	serverID string
+	uptime   float64
+	pending  bool // Set when the description must be fetched again, after a failed attempt
+}
+
+func newDescriptionCache(ttl time.Duration) *descriptionCache {
+	return &descriptionCache{ttl: ttl, checkInterval: identityCheckInterval}
+}
+
+// get returns the statistics description of the server, given the statistics fetched for the same scrape.
+// When fetching the description fails, the cached description is returned if there is one.
+func (c *descriptionCache) get(ctx context.Context, conn driver.Connection, stats Statistics) (StatisticsDescription, error) {
+	uptime, hasUptime := stats.GetGroup("server").GetFloat("uptime")
+
+	c.mutex.Lock()
+	descr := c.descr
+	refresh := descr == nil || c.pending || time.Since(c.fetched) > c.ttl
+	restarted := hasUptime && uptime < c.uptime
+	check := !refresh && (restarted || time.Since(c.checked) >= c.checkInterval)
+	if hasUptime {
+		c.uptime = uptime
+	}
+	if check {
+		// Concurrent scrapes do not check again
+		c.checked = time.Now()
+	}
+	cachedVersion, cachedServerID := c.version, c.serverID
+	c.mutex.Unlock()
+
+	if check {
+		if version, serverID, ok := c.identity(ctx, conn); ok && (version != cachedVersion || serverID != cachedServerID) {
+			log.Infof("Server changed from %s (%s) to %s (%s), fetching statistic descriptions", cachedServerID, cachedVersion, serverID, version)
+			refresh = true
+		}
+	}
+	if !refresh {
+		return *descr, nil
+	}
+	return c.refresh(ctx, conn)
+}
+
+// refresh fetches the statistics description, together with the identity of the server.
+// Concurrent refreshes are merged into a single request.
+func (c *descriptionCache) refresh(ctx context.Context, conn driver.Connection) (StatisticsDescription, error) {
+	result, err, _ := c.group.Do("refresh", func() (interface{}, error) {
+		descr, err := GetStatisticsDescription(ctx, conn)
+		if err != nil {
+			return nil, maskAny(err)
+		}
+		version, serverID, _ := c.identity(ctx, conn)
+
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+
+		c.descr = &descr
+		c.fetched = time.Now()
+		c.checked = c.fetched
+		c.pending = false
+		c.version, c.serverID = version, serverID
+		return descr, nil
+	})
+	if err != nil {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+
+		if c.descr != nil {
+			c.pending = true
+			log.Warnf("Failed to fetch statistic descriptions, using cached descriptions: %v", err)
+			return *c.descr, nil
+		}
+		return StatisticsDescription{}, maskAny(err)
+	}
+	return result.(StatisticsDescription), nil
+}
+
+// cached returns the cached statistics description, without fetching it.
+// If no description is cached, false is returned.
+func (c *descriptionCache) cached() (StatisticsDescription, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.descr == nil {
+		return StatisticsDescription{}, false
+	}
+	return *c.descr, true
+}
+
+// identity returns the version & ID of the server, false if the version cannot be fetched.
+// The ID is returned empty when it cannot be fetched, servers outside a cluster have no ID.
+func (c *descriptionCache) identity(ctx context.Context, conn driver.Connection) (driver.Version, string, bool) {
+	v, err := GetVersion(ctx, conn)
+	if err != nil {
+		log.Debugf("Failed to fetch server version: %v", err)
+		return "", "", false
+	}
+	var serverID string
+	if id, err := GetServerID(ctx, conn); err != nil {
+		log.Debugf("Failed to fetch server ID: %v", err)
+	} else {
+		serverID = id.ID
+	}
+	return v.Version, serverID, true
+}
@@ -0,0 +1,140 @@
+//
+// DISCLAIMER
+//
+// Copyright 2018 ArangoDB GmbH, Cologne, Germany
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Copyright holder is ArangoDB GmbH, Cologne, Germany
+//
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestDescriptionCache tests when the statistics description is fetched again.
+func TestDescriptionCache(t *testing.T) {
+	version := "3.5.1"
+	failing := false
+	fetched := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/_admin/statistics-description":
+			if failing {
+				http.Error(w, `{"error":true,"code":503}`, http.StatusServiceUnavailable)
+				return
+			}
+			fetched++
+			fmt.Fprintf(w, `{"groups":[{"group":"g%d"}],"figures":[]}`, fetched)
+		case "/_api/version":
+			fmt.Fprintf(w, `{"server":"arango","version":"%s"}`, version)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	conn, err := newConnClientFactory(server.URL, func() (string, error) { return "", nil }, newDriverTransport(false, time.Second))()
+	if err != nil {
+		t.Fatalf("Failed to create connection: %v", err)
+	}
+	uptime := func(u float64) Statistics {
+		return Statistics{"server": map[string]interface{}{"uptime": u}}
+	}
+
+	tests := []struct {
+		Uptime   float64
+		Version  string
+		Failing  bool
+		Expected string
+	}{
+		{10, "3.5.1", false, "g1"}, // Initial fetch
+		{20, "3.5.1", false, "g1"}, // Cached
+		{5, "3.5.1", false, "g1"},  // Restarted with the same version
+		{3, "3.5.2", false, "g2"},  // Restarted with another version
+		{2, "3.5.3", true, "g2"},   // Fetching fails, cached description is used
+		{4, "3.5.3", false, "g3"},  // Fetched again after the failure, without another restart
+	}
+
+	c := newDescriptionCache(time.Hour)
+	for i, test := range tests {
+		version = test.Version
+		failing = test.Failing
+		descr, err := c.get(context.Background(), conn, uptime(test.Uptime))
+		if err != nil {
+			t.Errorf("get for test %d failed: %v", i, err)
+		} else if len(descr.Groups) != 1 || descr.Groups[0].Group != test.Expected {
+			t.Errorf("get for test %d failed: got %v, expected group %s", i, descr.Groups, test.Expected)
+		}
+	}
+}
+
+// TestDescriptionCacheIdentity tests that the statistics description is fetched again when the version
+// of the server changes, also when the server does not report its uptime.
+func TestDescriptionCacheIdentity(t *testing.T) {
+	version := "3.5.1"
+	fetched := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/_admin/statistics-description":
+			fetched++
+			fmt.Fprintf(w, `{"groups":[{"group":"g%d"}],"figures":[]}`, fetched)
+		case "/_api/version":
+			if version == "" {
+				http.Error(w, `{"error":true,"code":503}`, http.StatusServiceUnavailable)
+				return
+			}
+			fmt.Fprintf(w, `{"server":"arango","version":"%s"}`, version)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	conn, err := newConnClientFactory(server.URL, func() (string, error) { return "", nil }, newDriverTransport(false, time.Second))()
+	if err != nil {
+		t.Fatalf("Failed to create connection: %v", err)
+	}
+
+	tests := []struct {
+		Version  string
+		Expected string
+	}{
+		{"3.5.1", "g1"}, // Initial fetch
+		{"3.5.1", "g1"}, // Same version
+		{"3.5.2", "g2"}, // Upgraded
+		{"", "g2"},      // Version is not available, the server is assumed to be unchanged
+		{"3.5.2", "g2"}, // Same version
+	}
+
+	c := newDescriptionCache(time.Hour)
+	c.checkInterval = 0
+	for i, test := range tests {
+		version = test.Version
+		descr, err := c.get(context.Background(), conn, Statistics{})
+		if err != nil {
+			t.Errorf("get for test %d failed: %v", i, err)
+		} else if len(descr.Groups) != 1 || descr.Groups[0].Group != test.Expected {
+			t.Errorf("get for test %d failed: got %v, expected group %s", i, descr.Groups, test.Expected)
+		}
+	}
+}
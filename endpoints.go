@@ -0,0 +1,190 @@
+//
+// DISCLAIMER
+//
+// Copyright 2018 ArangoDB GmbH, Cologne, Germany
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Copyright holder is ArangoDB GmbH, Cologne, Germany
+//
+
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+// parseEndpoints returns the endpoints in the given comma separated list.
+func parseEndpoints(arangodbEndpoint string) []string {
+	var result []string
+	for _, endpoint := range strings.Split(arangodbEndpoint, ",") {
+		if endpoint = strings.TrimSpace(endpoint); endpoint != "" {
+			result = append(result, endpoint)
+		}
+	}
+	return result
+}
+
+// availabilityCheck returns nil if the server at the given endpoint is available.
+// The followers of an Active Failover deployment are not available, only its leader is.
+type availabilityCheck func(ctx context.Context, endpoint string) error
+
+// endpointSelector selects the endpoint used to reach ArangoDB, when multiple endpoints are given.
+// The first available endpoint is selected at the first scrape, and again after a failed scrape.
+// With a single endpoint, that endpoint is always used.
+// A selector can be shared by the clients of a target, so they all use the same endpoint.
+type endpointSelector struct {
+	endpoints []string
+	available availabilityCheck
+	timeout   time.Duration
+	info      *prometheus.Desc
+
+	mutex     sync.Mutex
+	onChange  []func() // Called when another endpoint is selected
+	current   string
+	selected  bool
+	selecting bool
+}
+
+// newEndpointSelector returns a selector for the given comma separated endpoints.
+// The given client & labels are added to its metric, to tell the selectors of a target apart.
+func newEndpointSelector(arangodbEndpoint string, available availabilityCheck, timeout time.Duration, client string, labels prometheus.Labels) *endpointSelector {
+	constLabels := prometheus.Labels{"client": client}
+	for k, v := range labels {
+		constLabels[k] = v
+	}
+	endpoints := parseEndpoints(arangodbEndpoint)
+	s := &endpointSelector{
+		endpoints: endpoints,
+		available: available,
+		timeout:   timeout,
+		info: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "exporter_endpoint_info"),
+			"Endpoint used to reach ArangoDB.", []string{"endpoint"}, constLabels),
+	}
+	if len(endpoints) > 0 {
+		s.current = endpoints[0]
+	}
+	// There is nothing to select from a single endpoint
+	s.selected = len(endpoints) <= 1
+	return s
+}
+
+// get returns the endpoint to use, selecting it within the given context at the first call.
+func (s *endpointSelector) get(ctx context.Context) string {
+	s.mutex.Lock()
+	if s.selected {
+		defer s.mutex.Unlock()
+		return s.current
+	}
+	s.selected = true
+	current := s.current
+	// Concurrent scrapes use the first endpoint until the selection is done
+	s.mutex.Unlock()
+
+	return s.use(s.choose(ctx, current))
+}
+
+// subscribe adds the given function to the functions called when another endpoint is selected.
+func (s *endpointSelector) subscribe(fn func()) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.onChange = append(s.onChange, fn)
+}
+
+// currentEndpoint returns the endpoint to use, without selecting it.
+func (s *endpointSelector) currentEndpoint() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.current
+}
+
+// failover selects the endpoint to use again in the background, after the current endpoint failed.
+func (s *endpointSelector) failover() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(s.endpoints) <= 1 || s.selecting {
+		return
+	}
+	s.selecting = true
+	current := s.current
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+		defer cancel()
+
+		s.use(s.choose(ctx, current))
+		s.mutex.Lock()
+		s.selecting = false
+		s.mutex.Unlock()
+	}()
+}
+
+// choose returns the first available endpoint, starting with the endpoint following the given current endpoint.
+// When no endpoint is available, the current endpoint is returned.
+func (s *endpointSelector) choose(ctx context.Context, current string) string {
+	start := 0
+	for i, endpoint := range s.endpoints {
+		if endpoint == current {
+			start = i + 1
+		}
+	}
+	for i := range s.endpoints {
+		endpoint := s.endpoints[(start+i)%len(s.endpoints)]
+		if err := s.available(ctx, endpoint); err != nil {
+			log.Debugf("ArangoDB at %s is not available: %v", endpoint, err)
+			continue
+		}
+		return endpoint
+	}
+	log.Warnf("None of the ArangoDB endpoints is available, using %s", current)
+	return current
+}
+
+// use makes the given endpoint the endpoint to use and returns it.
+func (s *endpointSelector) use(endpoint string) string {
+	s.mutex.Lock()
+	previous := s.current
+	s.current = endpoint
+	onChange := s.onChange
+	s.mutex.Unlock()
+
+	if endpoint != previous {
+		log.Infof("Using ArangoDB at %s instead of %s", endpoint, previous)
+		for _, fn := range onChange {
+			fn()
+		}
+	}
+	return endpoint
+}
+
+// Describe implements prometheus.Collector.
+func (s *endpointSelector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- s.info
+}
+
+// Collect implements prometheus.Collector.
+func (s *endpointSelector) Collect(ch chan<- prometheus.Metric) {
+	s.mutex.Lock()
+	current := s.current
+	s.mutex.Unlock()
+
+	ch <- prometheus.MustNewConstMetric(s.info, prometheus.GaugeValue, 1, current)
+}
@@ -0,0 +1,94 @@
+//
+// DISCLAIMER
+//
+// Copyright 2018 ArangoDB GmbH, Cologne, Germany
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Copyright holder is ArangoDB GmbH, Cologne, Germany
+//
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestParseEndpoints tests parsing of comma separated endpoints for various inputs.
+func TestParseEndpoints(t *testing.T) {
+	tests := []struct {
+		Input    string
+		Expected []string
+	}{
+		{"http://a:8529", []string{"http://a:8529"}},
+		{"http://a:8529,http://b:8529", []string{"http://a:8529", "http://b:8529"}},
+		{" http://a:8529 , ,http://b:8529,", []string{"http://a:8529", "http://b:8529"}},
+		{"", nil},
+	}
+
+	for i, test := range tests {
+		if result := parseEndpoints(test.Input); !reflect.DeepEqual(result, test.Expected) {
+			t.Errorf("parseEndpoints for test %d failed: got %v, expected %v", i, result, test.Expected)
+		}
+	}
+}
+
+// TestEndpointSelector tests the selection of the leader and the failover to another endpoint.
+func TestEndpointSelector(t *testing.T) {
+	var mutex sync.Mutex
+	leader := "http://b:8529"
+	available := func(ctx context.Context, endpoint string) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if endpoint != leader {
+			return fmt.Errorf("Not the leader")
+		}
+		return nil
+	}
+	var changes int32
+	s := newEndpointSelector("http://a:8529,http://b:8529,http://c:8529", available, time.Second, "test", nil)
+	s.subscribe(func() { atomic.AddInt32(&changes, 1) })
+
+	if result := s.get(context.Background()); result != "http://b:8529" {
+		t.Errorf("get failed: got %s, expected the leader", result)
+	}
+
+	mutex.Lock()
+	leader = "http://a:8529"
+	mutex.Unlock()
+	s.failover()
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&changes) < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if result := s.currentEndpoint(); result != "http://a:8529" {
+		t.Errorf("failover failed: got %s, expected the new leader", result)
+	}
+	if n := atomic.LoadInt32(&changes); n != 2 {
+		t.Errorf("failover failed: got %d changes, expected 2", n)
+	}
+
+	// Without available endpoint, the current endpoint is kept
+	mutex.Lock()
+	leader = ""
+	mutex.Unlock()
+	if result := s.choose(context.Background(), "http://a:8529"); result != "http://a:8529" {
+		t.Errorf("choose without available endpoint failed: got %s, expected the current endpoint", result)
+	}
+}
@@ -26,9 +26,10 @@ import (
 	"context"
 	"crypto/tls"
 	"fmt"
+	"net"
+	"net/http"
 	_ "net/http/pprof"
 	"strings"
-	"sync"
 	"time"
 
 	driver "github.com/arangodb/go-driver"
@@ -42,7 +43,11 @@ const (
 
 )
 
-// metricKey returns a key into the map of metrics for the given figure & group.
+// legacyMetricTypes exposes accumulated figures as gauges and distributions as separate
+// _sum, _count & _bucket gauges, as done by previous versions of the exporter.
+var legacyMetricTypes = false
+
+// metricKey returns the name of the metric for the given figure & group.
 func metricKey(group StatisticGroup, figure StatisticFigure, postfix string) string {
 	result := strings.Replace(strings.ToLower(group.Name+"_"+figure.Name), " ", "_", -1)
 	if postfix != "" {
@@ -54,87 +59,220 @@ func metricKey(group StatisticGroup, figure StatisticFigure, postfix string) str
 	return result
 }
 
-// newMetric creates one or more metrics for the given figure & group.
-func newMetric(group StatisticGroup, figure StatisticFigure) []prometheus.Collector {
+// figureDesc returns the description of a metric with the given name for the given figure.
+func figureDesc(name string, figure StatisticFigure, labels prometheus.Labels, variableLabels ...string) *prometheus.Desc {
+	return prometheus.NewDesc(prometheus.BuildFQName(namespace, "", name), figure.Description, variableLabels, labels)
+}
+
+// figureMetrics creates the metrics for the given figure & group from the given statistics of the group.
+// The given labels are added to all created metrics.
+func figureMetrics(group StatisticGroup, figure StatisticFigure, groupStats Statistics, labels prometheus.Labels) ([]prometheus.Metric, error) {
+	if legacyMetricTypes {
+		return legacyFigureMetrics(group, figure, groupStats, labels)
+	}
 	switch figure.Type {
+	case FigureTypeCurrent:
+		value, ok := groupStats.GetFloat(figure.Identifier)
+		if !ok {
+			return nil, nil
+		}
+		m, err := prometheus.NewConstMetric(figureDesc(metricKey(group, figure, ""), figure, labels), prometheus.GaugeValue, value)
+		if err != nil {
+			return nil, maskAny(err)
+		}
+		return []prometheus.Metric{m}, nil
+	case FigureTypeAccumulated:
+		value, ok := groupStats.GetFloat(figure.Identifier)
+		if !ok {
+			return nil, nil
+		}
+		m, err := prometheus.NewConstMetric(figureDesc(metricKey(group, figure, "")+"_total", figure, labels), prometheus.CounterValue, value)
+		if err != nil {
+			return nil, maskAny(err)
+		}
+		return []prometheus.Metric{m}, nil
 	case FigureTypeDistribution:
-		return []prometheus.Collector{
-			// _sum
-			prometheus.NewGauge(prometheus.GaugeOpts{
-				Namespace: namespace,
-				Name:      metricKey(group, figure, "_sum"),
-				Help:      figure.Description,
-			}),
-			// _count
-			prometheus.NewGauge(prometheus.GaugeOpts{
-				Namespace: namespace,
-				Name:      metricKey(group, figure, "_count"),
-				Help:      figure.Description,
-			}),
-			// _bucket
-			prometheus.NewGaugeVec(prometheus.GaugeOpts{
-				Namespace: namespace,
-				Name:      metricKey(group, figure, "_bucket"),
-				Help:      figure.Description,
-			}, []string{"le"}),
+		distStats := groupStats.GetGroup(figure.Identifier)
+		if distStats == nil {
+			return nil, nil
 		}
+		sum, _ := distStats.GetFloat("sum")
+		count, _ := distStats.GetFloat("count")
+		counts, _ := distStats.GetCounts("counts")
+		// The last count is that of the +Inf bucket, which is implied by the total count
+		buckets := make(map[float64]uint64, len(figure.Cuts))
+		cummulative := int64(0)
+		for i, v := range counts {
+			if i >= len(figure.Cuts) {
+				break
+			}
+			cummulative += v
+			buckets[figure.Cuts[i]] = uint64(cummulative)
+		}
+		m, err := prometheus.NewConstHistogram(figureDesc(metricKey(group, figure, ""), figure, labels), uint64(count), sum, buckets)
+		if err != nil {
+			return nil, maskAny(err)
+		}
+		return []prometheus.Metric{m}, nil
 	default:
-		return []prometheus.Collector{
-			prometheus.NewGauge(prometheus.GaugeOpts{
-				Namespace: namespace,
-				Name:      metricKey(group, figure, ""),
-				Help:      figure.Description,
-			}),
+		return nil, nil
+	}
+}
+
+// legacyFigureMetrics creates the metrics for the given figure & group from the given statistics of the group,
+// as gauges, as done by previous versions. The given labels are added to all created metrics.
+func legacyFigureMetrics(group StatisticGroup, figure StatisticFigure, groupStats Statistics, labels prometheus.Labels) ([]prometheus.Metric, error) {
+	var result []prometheus.Metric
+	add := func(desc *prometheus.Desc, value float64, labelValues ...string) error {
+		m, err := prometheus.NewConstMetric(desc, prometheus.GaugeValue, value, labelValues...)
+		if err != nil {
+			return maskAny(err)
+		}
+		result = append(result, m)
+		return nil
+	}
+
+	switch figure.Type {
+	case FigureTypeCurrent, FigureTypeAccumulated:
+		if value, ok := groupStats.GetFloat(figure.Identifier); ok {
+			if err := add(figureDesc(metricKey(group, figure, ""), figure, labels), value); err != nil {
+				return nil, maskAny(err)
+			}
+		}
+	case FigureTypeDistribution:
+		distStats := groupStats.GetGroup(figure.Identifier)
+		if distStats == nil {
+			return nil, nil
+		}
+		// _sum comes first
+		if sum, ok := distStats.GetFloat("sum"); ok {
+			if err := add(figureDesc(metricKey(group, figure, "_sum"), figure, labels), sum); err != nil {
+				return nil, maskAny(err)
+			}
+		}
+		// _count comes second
+		if count, ok := distStats.GetFloat("count"); ok {
+			if err := add(figureDesc(metricKey(group, figure, "_count"), figure, labels), count); err != nil {
+				return nil, maskAny(err)
+			}
+		}
+		// _bucket comes third
+		if counts, ok := distStats.GetCounts("counts"); ok {
+			desc := figureDesc(metricKey(group, figure, "_bucket"), figure, labels, "le")
+			cummulative := int64(0)
+			for i, v := range counts {
+				var leValue string
+				if i < len(figure.Cuts) {
+					leValue = fmt.Sprintf("%v", figure.Cuts[i])
+				} else {
+					leValue = "+Inf"
+				}
+				cummulative += v
+				if err := add(desc, float64(cummulative), leValue); err != nil {
+					return nil, maskAny(err)
+				}
+			}
 		}
 	}
+	return result, nil
 }
 
 // Exporter collects ArangoDB statistics from the given endpoint and exports them using
 // the prometheus metrics package.
+// Every scrape creates new metrics from the fetched statistics, so concurrent scrapes do not share any state.
+// The metrics are created by named collectors, a failing collector does not affect the others.
 type Exporter struct {
-	factory connClientFactory
-	timeout time.Duration
-	mutex   sync.RWMutex
+	auth         Authentication
+	transport    *retryTransport
+	endpoints    *endpointSelector
+	ownEndpoints bool // Set when the endpoint selector is not shared with another client, which exposes it
+	collectors   []namedCollector
 
-	metrics                     map[string][]prometheus.Collector
-	up                          prometheus.Gauge
-	totalScrapes, failedScrapes prometheus.Counter
+	up                                  *prometheus.Desc
+	collectorSuccess, collectorDuration *prometheus.Desc
+	totalScrapes, failedScrapes         prometheus.Counter
 }
 
 // NewExporter returns an initialized Exporter.
 func NewExporter(arangodbEndpoint string, jwt Authentication, sslVerify bool, timeout time.Duration) (*Exporter, error) {
+	return newExporter(arangodbEndpoint, jwt, sslVerify, timeout, nil, nil), nil
+}
 
-	return &Exporter{
-		factory: newConnClientFactory(arangodbEndpoint, jwt, sslVerify, timeout),
-		timeout: timeout,
-		up: prometheus.NewGauge(prometheus.GaugeOpts{
-			Namespace: namespace,
-			Name:      "up",
-			Help:      "Was the last scrape of ArangoDB successful.",
-		}),
+// newExporter returns an initialized Exporter that adds the given labels to all its metrics.
+// When an endpoint selector is given, the endpoint is selected by that selector, which is shared with
+// another client of the same target that exposes it. Otherwise it is selected from the given endpoints.
+// The cluster health collector is left out when the labels identify a cluster member,
+// the ClusterExporter exposes the health of all members itself.
+func newExporter(arangodbEndpoint string, auth Authentication, sslVerify bool, timeout time.Duration, labels prometheus.Labels, endpoints *endpointSelector) *Exporter {
+	transport := newRetryTransport(newDriverTransport(sslVerify, timeout), retryOptions, "internal", labels)
+	ownEndpoints := endpoints == nil
+	if ownEndpoints {
+		// Availability is checked without retries, to quickly find an available endpoint
+		available := func(ctx context.Context, endpoint string) error {
+			conn, err := newConnClientFactory(endpoint, auth, transport.next)()
+			if err != nil {
+				return maskAny(err)
+			}
+			return GetServerAvailability(ctx, conn)
+		}
+		endpoints = newEndpointSelector(arangodbEndpoint, available, timeout, "internal", labels)
+	}
+	endpoints.subscribe(transport.breaker.reset)
+	descriptions := newDescriptionCache(descriptionTTL)
+	collectors := map[string]collector{
+		collectorActiveFailover: newActiveFailoverCollector(labels),
+		collectorStatistics:     &statisticsCollector{descriptions: descriptions, labels: labels},
+		collectorServer:         &serverCollector{descriptions: descriptions, labels: labels},
+	}
+	if _, found := labels["server_id"]; !found {
+		collectors[collectorClusterHealth] = newClusterHealthCollector(labels)
+	}
+	e := &Exporter{
+		auth:         auth,
+		transport:    transport,
+		endpoints:    endpoints,
+		ownEndpoints: ownEndpoints,
+		up: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "up"),
+			"Was the last scrape of ArangoDB successful.", nil, labels),
+		collectorSuccess: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "exporter_collector_success"),
+			"Was the last scrape of the collector successful.", []string{"collector"}, labels),
+		collectorDuration: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "exporter_collector_duration_seconds"),
+			"Duration of the last scrape of the collector.", []string{"collector"}, labels),
 		totalScrapes: prometheus.NewCounter(prometheus.CounterOpts{
-			Namespace: namespace,
-			Name:      "exporter_total_scrapes",
-			Help:      "Current total ArangoDB scrapes.",
+			Namespace:   namespace,
+			Name:        "exporter_total_scrapes",
+			Help:        "Current total ArangoDB scrapes.",
+			ConstLabels: labels,
 		}),
 		failedScrapes: prometheus.NewCounter(prometheus.CounterOpts{
-			Namespace: namespace,
-			Name:      "exporter_failed_scrapes",
-			Help:      "Number of failed ArangoDB scrapes",
+			Namespace:   namespace,
+			Name:        "exporter_failed_scrapes",
+			Help:        "Number of failed ArangoDB scrapes",
+			ConstLabels: labels,
 		}),
-		metrics: make(map[string][]prometheus.Collector),
-	}, nil
+	}
+	for _, name := range collectorNames {
+		if _, found := collectors[name]; !found || !isCollectorEnabled(name) {
+			continue
+		}
+		collectorTimeout := timeout
+		if t, found := collectorTimeouts[name]; found {
+			collectorTimeout = t
+		}
+		e.collectors = append(e.collectors, namedCollector{name: name, timeout: collectorTimeout, collector: collectors[name]})
+	}
+	return e
 }
 
 type connClientFactory func() (driver.Connection, error)
 
-func newConnClientFactory(arangodbEndpoint string, auth Authentication, sslVerify bool, timeout time.Duration) connClientFactory {
+// newConnClientFactory returns a factory of connections to the given endpoint, sending requests through the given transport.
+// The JWT is requested from the given authentication for every connection.
+func newConnClientFactory(arangodbEndpoint string, auth Authentication, transport http.RoundTripper) connClientFactory {
 	return func() (driver.Connection, error) {
 		connCfg := driver_http.ConnectionConfig{
 			Endpoints: []string{arangodbEndpoint},
-		}
-		if !sslVerify {
-			connCfg.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+			Transport: transport,
 		}
 
 		jwt, err := auth()
@@ -162,146 +300,95 @@ func newConnClientFactory(arangodbEndpoint string, auth Authentication, sslVerif
 	}
 }
 
-// Describe describes all the metrics ever exported by the HAProxy exporter. It
-// implements prometheus.Collector.
-func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
-	for _, ms := range e.metrics {
-		for _, m := range ms {
-			m.Describe(ch)
-		}
+// newDriverTransport creates a long-lived transport for connections to ArangoDB, reusing its connections across scrapes.
+func newDriverTransport(sslVerify bool, timeout time.Duration) *http.Transport {
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   timeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:        driver_http.DefaultMaxIdleConnsPerHost,
+		MaxIdleConnsPerHost: driver_http.DefaultMaxIdleConnsPerHost,
+		IdleConnTimeout:     90 * time.Second,
+		TLSHandshakeTimeout: timeout,
+	}
+	if !sslVerify {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
 	}
-	ch <- e.up.Desc()
-	ch <- e.totalScrapes.Desc()
-	ch <- e.failedScrapes.Desc()
+	return transport
+}
+
+// CloseIdleConnections closes the idle connections to ArangoDB.
+func (e *Exporter) CloseIdleConnections() {
+	e.transport.CloseIdleConnections()
+}
+
+// Describe sends no descriptors, since the metrics of the statistics depend on the server.
+// This makes the Exporter an unchecked collector. It implements prometheus.Collector.
+func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
 }
 
 // Collect fetches the stats from ArangoDB statistics and delivers them
 // as Prometheus metrics. It implements prometheus.Collector.
 func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
-	e.mutex.Lock() // To protect metrics from concurrent collects.
-	defer e.mutex.Unlock()
-
-	ctx, cancel := context.WithTimeout(context.Background(), e.timeout)
-	defer cancel()
-	e.resetMetrics()
-	e.scrape(ctx)
-
-	ch <- e.up
-	ch <- e.totalScrapes
-	ch <- e.failedScrapes
-	e.collectMetrics(ch)
+	e.CollectContext(context.Background(), ch)
 }
 
-// scrape performs a single query of all statistics.
-func (e *Exporter) scrape(ctx context.Context) {
+// CollectContext is Collect, where every collector gives up when either its own timeout expires
+// or the given context is done. ArangoDB is reported as up when at least one collector succeeded.
+// It implements contextCollector.
+func (e *Exporter) CollectContext(ctx context.Context, ch chan<- prometheus.Metric) {
 	e.totalScrapes.Inc()
-
-	conn, err := e.factory()
-	if err != nil {
-		e.up.Set(0)
-		log.Errorf("Failed to create client: %v", err)
-		return
-	}
-
-	// Gather descriptions
-	descr, err := GetStatisticsDescription(ctx, conn)
-	if err != nil {
-		e.up.Set(0)
-		log.Errorf("Failed to fetch statistic descriptions: %v", err)
-		return
-	}
-
-	// Collect statistics
-	stats, err := GetStatistics(ctx, conn)
-	if err != nil {
-		e.up.Set(0)
-		log.Errorf("Failed to fetch statistics: %v", err)
-		return
-	}
-
-	// Mark ArangoDB as up.
-	e.up.Set(1)
-
-	// Now parse the statistics & put them in the correct metrics
-	groups := make(map[string]StatisticGroup)
-	for _, g := range descr.Groups {
-		groups[g.Group] = g
-	}
-	for _, f := range descr.Figures {
-		group, found := groups[f.Group]
-		if !found {
-			// Skip figure with unknown group
-			continue
+	s := &scrape{factory: newConnClientFactory(e.endpoints.get(ctx), e.auth, e.transport)}
+	succeeded, failed := 0, 0
+	for _, c := range e.collectors {
+		if afLeaderOnly && s.follower {
+			// Only the leader is scraped, the selected endpoint may no longer be the leader
+			e.endpoints.failover()
+			break
 		}
-		groupStats := stats.GetGroup(f.Group)
-		if groupStats == nil {
-			// Skip no group is found in the statistics
+		metrics, err := e.collect(ctx, c, s, ch)
+		if err != nil {
+			failed++
+		} else {
+			succeeded++
 		}
-		key := metricKey(group, f, "")
-		ms, found := e.metrics[key]
-		if !found {
-			ms = newMetric(group, f)
-			e.metrics[key] = ms
-		}
-		switch f.Type {
-		case FigureTypeCurrent, FigureTypeAccumulated:
-			if value, ok := groupStats.GetFloat(f.Identifier); ok {
-				gauge := ms[0].(prometheus.Gauge)
-				gauge.Set(value)
-			}
-		case FigureTypeDistribution:
-			distStats := groupStats.GetGroup(f.Identifier)
-			if distStats != nil {
-				// _sum comes first
-				if sum, ok := distStats.GetFloat("sum"); ok {
-					gauge := ms[0].(prometheus.Gauge)
-					gauge.Set(sum)
-				}
-				// _count comes second
-				if sum, ok := distStats.GetFloat("count"); ok {
-					gauge := ms[1].(prometheus.Gauge)
-					gauge.Set(sum)
-				}
-				// _bucket comes third
-				if counts, ok := distStats.GetCounts("counts"); ok {
-					gaugeVec := ms[2].(*prometheus.GaugeVec)
-					cummulative := int64(0)
-					for i, v := range counts {
-						var leValue string
-						if i < len(f.Cuts) {
-							leValue = fmt.Sprintf("%v", f.Cuts[i])
-						} else {
-							leValue = "+Inf"
-						}
-						gaugeVec.WithLabelValues(leValue).Set(float64(cummulative + v))
-						cummulative += v
-					}
-				}
-			}
+		for _, m := range metrics {
+			ch <- m
 		}
 	}
-}
 
-type resetter interface {
-	Reset()
-}
-
-func (e *Exporter) resetMetrics() {
-	for _, ms := range e.metrics {
-		for _, m := range ms {
-			if r, ok := m.(resetter); ok {
-				r.Reset()
-			}
-		}
+	up := 1.0
+	if succeeded == 0 {
+		up = 0
+		e.endpoints.failover()
+	}
+	if failed > 0 {
+		e.failedScrapes.Inc()
+	}
+	ch <- prometheus.MustNewConstMetric(e.up, prometheus.GaugeValue, up)
+	ch <- e.totalScrapes
+	ch <- e.failedScrapes
+	e.transport.Collect(ch)
+	if e.ownEndpoints {
+		e.endpoints.Collect(ch)
 	}
 }
 
-func (e *Exporter) collectMetrics(metrics chan<- prometheus.Metric) {
-	for _, ms := range e.metrics {
-		for _, m := range ms {
-			if c, ok := m.(prometheus.Collector); ok {
-				c.Collect(metrics)
-			}
-		}
+// collect runs the given collector within its timeout and delivers its success & duration.
+func (e *Exporter) collect(ctx context.Context, c namedCollector, s *scrape, ch chan<- prometheus.Metric) ([]prometheus.Metric, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	start := time.Now()
+	metrics, err := c.collector.collect(ctx, s)
+	success := 1.0
+	if err != nil {
+		success = 0
+		log.Debugf("Collector %s failed: %v", c.name, err)
 	}
+	ch <- prometheus.MustNewConstMetric(e.collectorDuration, prometheus.GaugeValue, time.Since(start).Seconds(), c.name)
+	ch <- prometheus.MustNewConstMetric(e.collectorSuccess, prometheus.GaugeValue, success, c.name)
+	return metrics, err
 }
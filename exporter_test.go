@@ -24,51 +24,182 @@ package main
 
 import (
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	_ "net/http/pprof"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+
+	dto "github.com/prometheus/client_model/go"
 )
 
-// TestMetric tests the result of metricKey & newMetric for various inputs.
+// figureStats are the statistics of group g1, used to test the metrics of figures.
+var figureStats = Statistics{
+	"f0": 3.0,
+	"f1": 12.0,
+	"f2": map[string]interface{}{
+		"sum":    1.5,
+		"count":  6.0,
+		"counts": []interface{}{1.0, 2.0, 3.0},
+	},
+}
+
+// TestMetric tests the result of metricKey & figureMetrics for various inputs.
+// Names that are not valid metric names, such as those containing a dash, result in an error.
 func TestMetric(t *testing.T) {
 	g1 := StatisticGroup{
 		Group:       "g1",
 		Name:        "g1-name",
 		Description: "Something g1",
 	}
+	v1 := StatisticGroup{
+		Group:       "g1",
+		Name:        "g1_name",
+		Description: "Something g1",
+	}
 	tests := []struct {
-		Group               StatisticGroup
-		Figure              StatisticFigure
-		Postfix             string
-		KeyResult           string
-		CollectionPostfixes []string
+		Group           StatisticGroup
+		Figure          StatisticFigure
+		Legacy          bool
+		Postfix         string
+		KeyResult       string
+		MetricPostfixes []string
+		Invalid         bool
 	}{
-		{g1, StatisticFigure{"g1", "f1", "f1-name", "descr-f1", FigureTypeAccumulated, "", nil}, "_pf", "g1-name_f1-name_pf", []string{""}},
-		{g1, StatisticFigure{"g1", "f1", "f1-name", "descr-f1", FigureTypeAccumulated, "tick", nil}, "_pf", "g1-name_f1-name_pf_tick", []string{""}},
-		{g1, StatisticFigure{"g1", "f2", "f2-name", "descr-f2", FigureTypeDistribution, "", []float64{0.1, 0.2}}, "_pf", "g1-name_f2-name_pf", []string{"_sum", "_count", "_bucket"}},
+		{g1, StatisticFigure{"g1", "f1", "f1-name", "descr-f1", FigureTypeAccumulated, "", nil}, true, "_pf", "g1-name_f1-name_pf", nil, true},
+		{g1, StatisticFigure{"g1", "f1", "f1-name", "descr-f1", FigureTypeAccumulated, "tick", nil}, true, "_pf", "g1-name_f1-name_pf_tick", nil, true},
+		{g1, StatisticFigure{"g1", "f2", "f2-name", "descr-f2", FigureTypeDistribution, "", []float64{0.1, 0.2}}, true, "_pf", "g1-name_f2-name_pf", nil, true},
+		{g1, StatisticFigure{"g1", "f1", "f1-name", "descr-f1", FigureTypeAccumulated, "", nil}, false, "_pf", "g1-name_f1-name_pf", nil, true},
+		{v1, StatisticFigure{"g1", "f0", "f0_name", "descr-f0", FigureTypeCurrent, "", nil}, false, "_pf", "g1_name_f0_name_pf", []string{"g1_name_f0_name"}, false},
+		{v1, StatisticFigure{"g1", "f1", "f1_name", "descr-f1", FigureTypeAccumulated, "", nil}, false, "_pf", "g1_name_f1_name_pf", []string{"g1_name_f1_name_total"}, false},
+		{v1, StatisticFigure{"g1", "f1", "f1_name", "descr-f1", FigureTypeAccumulated, "tick", nil}, false, "_pf", "g1_name_f1_name_pf_tick", []string{"g1_name_f1_name_tick_total"}, false},
+		{v1, StatisticFigure{"g1", "f2", "f2_name", "descr-f2", FigureTypeDistribution, "", []float64{0.1, 0.2}}, false, "_pf", "g1_name_f2_name_pf", []string{"g1_name_f2_name"}, false},
+		{v1, StatisticFigure{"g1", "f1", "f1_name", "descr-f1", FigureTypeAccumulated, "", nil}, true, "_pf", "g1_name_f1_name_pf", []string{""}, false},
+		{v1, StatisticFigure{"g1", "f1", "f1_name", "descr-f1", FigureTypeAccumulated, "tick", nil}, true, "_pf", "g1_name_f1_name_pf_tick", []string{""}, false},
+		{v1, StatisticFigure{"g1", "f2", "f2_name", "descr-f2", FigureTypeDistribution, "", []float64{0.1, 0.2}}, true, "_pf", "g1_name_f2_name_pf", []string{"_sum", "_count", "_bucket", "_bucket", "_bucket"}, false},
+		{v1, StatisticFigure{"g1", "f3", "f3_name", "descr-f3", FigureTypeCurrent, "", nil}, false, "", "g1_name_f3_name", nil, false},
 	}
+	defer func() {
+		legacyMetricTypes = false
+	}()
 
 	for i, test := range tests {
 		result := metricKey(test.Group, test.Figure, test.Postfix)
 		if result != test.KeyResult {
 			t.Errorf("metricKey for test %d failed: got '%s', expected '%s'", i, result, test.KeyResult)
 		}
-		colls := newMetric(test.Group, test.Figure)
-		if len(colls) != len(test.CollectionPostfixes) {
-			t.Errorf("newMetric for test %d returns unexpected #collectors: got %d, expected %d", i, len(colls), len(test.CollectionPostfixes))
+		legacyMetricTypes = test.Legacy
+		metrics, err := figureMetrics(test.Group, test.Figure, figureStats, nil)
+		if test.Invalid {
+			if err == nil {
+				t.Errorf("figureMetrics for test %d succeeded, expected an error for invalid name %s", i, test.KeyResult)
+			}
+		} else if err != nil {
+			t.Errorf("figureMetrics for test %d failed: %v", i, err)
+		} else if len(metrics) != len(test.MetricPostfixes) {
+			t.Errorf("figureMetrics for test %d returns unexpected #metrics: got %d, expected %d", i, len(metrics), len(test.MetricPostfixes))
 		} else {
-			for ci, c := range colls {
-				descrChan := make(chan *prometheus.Desc, 1)
-				c.Describe(descrChan)
-				d := <-descrChan
-				result := d.String()
-				expectedPostfix := test.CollectionPostfixes[ci]
+			for mi, m := range metrics {
+				result := m.Desc().String()
+				expectedPostfix := test.MetricPostfixes[mi]
 				if !strings.Contains(result, fmt.Sprintf("%s\", help", expectedPostfix)) {
-					t.Errorf("newMetric for test %d returns collector %d with wrong expectation. got '%s', expected it to contain '%s'", i, ci, result, expectedPostfix)
+					t.Errorf("figureMetrics for test %d returns metric %d with wrong expectation. got '%s', expected it to contain '%s'", i, mi, result, expectedPostfix)
 				}
 			}
 		}
 	}
 }
+
+// TestFigureMetrics tests the values of the metrics created from statistics for various figure types.
+func TestFigureMetrics(t *testing.T) {
+	g1 := StatisticGroup{Group: "g1", Name: "g1"}
+	tests := []struct {
+		Figure   StatisticFigure
+		Expected string
+	}{
+		{StatisticFigure{"g1", "f0", "f0", "descr-f0", FigureTypeCurrent, "", nil}, "gauge:<value:3 > "},
+		{StatisticFigure{"g1", "f1", "f1", "descr-f1", FigureTypeAccumulated, "", nil}, "counter:<value:12 > "},
+		{StatisticFigure{"g1", "f2", "f2", "descr-f2", FigureTypeDistribution, "", []float64{0.1, 0.2}},
+			"histogram:<sample_count:6 sample_sum:1.5 bucket:<cumulative_count:1 upper_bound:0.1 > bucket:<cumulative_count:3 upper_bound:0.2 > > "},
+	}
+
+	for i, test := range tests {
+		metrics, err := figureMetrics(g1, test.Figure, figureStats, nil)
+		if err != nil || len(metrics) != 1 {
+			t.Fatalf("figureMetrics for test %d failed: got %d metrics, error %v", i, len(metrics), err)
+		}
+		var m dto.Metric
+		if err := metrics[0].Write(&m); err != nil {
+			t.Fatalf("Write for test %d failed: %v", i, err)
+		}
+		if result := m.String(); result != test.Expected {
+			t.Errorf("figureMetrics for test %d failed: got '%s', expected '%s'", i, result, test.Expected)
+		}
+	}
+}
+
+// TestExporterCollect tests concurrent scrapes of the exporter, including figures that vanish between scrapes.
+func TestExporterCollect(t *testing.T) {
+	var descriptions, statistics int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/_admin/statistics-description":
+			atomic.AddInt32(&descriptions, 1)
+			w.Write([]byte(`{"groups":[{"group":"client","name":"Client","description":"Client"}],"figures":[
+				{"group":"client","identifier":"httpConnections","name":"Client Connections","description":"Connections","type":"current"},
+				{"group":"client","identifier":"bytesSent","name":"Bytes Sent","description":"Bytes","type":"accumulated","unit":"bytes"}]}`))
+		case "/_admin/statistics":
+			// The second figure vanishes after the first scrapes
+			if atomic.AddInt32(&statistics, 1) <= 4 {
+				w.Write([]byte(`{"client":{"httpConnections":3,"bytesSent":10}}`))
+			} else {
+				w.Write([]byte(`{"client":{"httpConnections":3}}`))
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	e, _ := NewExporter(server.URL, func() (string, error) { return "", nil }, false, time.Second)
+	// A pedantic registry checks that the collected metrics are consistent with the described ones
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(e)
+
+	names := func() map[string]bool {
+		families, err := registry.Gather()
+		if err != nil {
+			t.Errorf("Gather failed: %v", err)
+		}
+		result := make(map[string]bool)
+		for _, mf := range families {
+			result[mf.GetName()] = true
+		}
+		return result
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if result := names(); !result["arangodb_client_bytes_sent_bytes_total"] || !result["arangodb_client_client_connections"] {
+				t.Errorf("Concurrent gather failed: got %v", result)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if result := names(); result["arangodb_client_bytes_sent_bytes_total"] || !result["arangodb_up"] {
+		t.Errorf("Gather after a figure vanished failed: got %v", result)
+	}
+	if n := atomic.LoadInt32(&descriptions); n != 1 {
+		t.Errorf("Statistic descriptions are not cached: got %d requests, expected 1", n)
+	}
+}
@@ -0,0 +1,243 @@
+//
+// DISCLAIMER
+//
+// Copyright 2018 ArangoDB GmbH, Cologne, Germany
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Copyright holder is ArangoDB GmbH, Cologne, Germany
+//
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// errResponseTooLarge is returned when a response exceeds the maximum body size.
+var errResponseTooLarge = fmt.Errorf("Response exceeds the maximum body size")
+
+// parseError is returned when (part of) the metrics could not be parsed.
+type parseError struct {
+	cause error
+}
+
+func (e parseError) Error() string {
+	return e.cause.Error()
+}
+
+// readFamilies reads metrics in Prometheus text format and calls fn for every metric family,
+// in the order in which they are read. Metric families are parsed one at a time, so the metrics
+// never have to be kept in memory as a whole. Known quirks of ArangoDB output are fixed on the way.
+// Metric families that cannot be parsed are skipped, after which a parseError is returned.
+func readFamilies(r io.Reader, fn func(*dto.MetricFamily) error) error {
+	reader := newFamilyReader(r)
+	var lastParseErr error
+	for {
+		chunk, err := reader.next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return maskAny(err)
+		}
+
+		var parser expfmt.TextParser
+		parsed, err := parser.TextToMetricFamilies(bytes.NewReader(fixTypeLines(chunk)))
+		if err != nil {
+			lastParseErr = err
+			continue
+		}
+		for _, mf := range sortFamilies(parsed) {
+			if err := fn(mf); err != nil {
+				return maskAny(err)
+			}
+		}
+	}
+	if lastParseErr != nil {
+		return maskAny(parseError{cause: lastParseErr})
+	}
+	return nil
+}
+
+// parseMetrics parses the given metrics in Prometheus text format into metric families,
+// sorted by name. Known quirks of ArangoDB output are fixed on the way and
+// metric families returned in separate places are merged.
+// When some of the metric families cannot be parsed, the others are returned together with the error.
+func parseMetrics(response []byte) ([]*dto.MetricFamily, error) {
+	parsed := make(map[string]*dto.MetricFamily)
+	err := readFamilies(bytes.NewReader(response), func(mf *dto.MetricFamily) error {
+		mergeFamily(parsed, mf)
+		return nil
+	})
+	return sortFamilies(parsed), err
+}
+
+// sortFamilies returns the given metric families, sorted by name.
+func sortFamilies(families map[string]*dto.MetricFamily) []*dto.MetricFamily {
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := make([]*dto.MetricFamily, 0, len(names))
+	for _, name := range names {
+		result = append(result, families[name])
+	}
+	return result
+}
+
+// typeFixes maps metric types reported by some ArangoDB versions to the correct ones.
+var typeFixes = map[string]string{
+	"guage": "gauge",
+}
+
+// fixTypeLines fixes the metric type in all TYPE lines of the given metrics,
+// leaving metric names, label values & HELP texts untouched.
+func fixTypeLines(response []byte) []byte {
+	lines := bytes.Split(response, []byte("\n"))
+	for i, line := range lines {
+		fields := strings.Fields(string(line))
+		if len(fields) != 4 || fields[0] != "#" || fields[1] != "TYPE" {
+			continue
+		}
+		metricType := strings.ToLower(fields[3])
+		if fixed, found := typeFixes[metricType]; found {
+			metricType = fixed
+		}
+		if metricType != fields[3] {
+			lines[i] = []byte(strings.Join([]string{"#", "TYPE", fields[2], metricType}, " "))
+		}
+	}
+	return bytes.Join(lines, []byte("\n"))
+}
+
+// familyReader splits metrics in Prometheus text format into chunks of lines,
+// each containing a single metric family.
+type familyReader struct {
+	r       *bufio.Reader
+	pending []byte // First line of the next chunk
+	eof     bool
+}
+
+func newFamilyReader(r io.Reader) *familyReader {
+	return &familyReader{r: bufio.NewReader(r)}
+}
+
+// next returns the lines of the next metric family, or io.EOF when all lines have been read.
+func (f *familyReader) next() ([]byte, error) {
+	var chunk []byte
+	var name string
+	if f.pending != nil {
+		chunk = f.pending
+		name = lineFamily(f.pending, "")
+		f.pending = nil
+	}
+
+	for !f.eof {
+		line, err := f.r.ReadBytes('\n')
+		if err == io.EOF {
+			f.eof = true
+			if len(line) == 0 {
+				break
+			}
+			line = append(line, '\n')
+		} else if err != nil {
+			return nil, maskAny(err)
+		}
+
+		lineName := lineFamily(line, name)
+		if name == "" {
+			name = lineName
+		} else if lineName != name {
+			f.pending = line
+			return chunk, nil
+		}
+		chunk = append(chunk, line...)
+	}
+
+	if len(chunk) == 0 {
+		return nil, io.EOF
+	}
+	return chunk, nil
+}
+
+// lineFamily returns the name of the metric family the given line belongs to.
+// Lines without a family of their own, such as comments, belong to the current family.
+func lineFamily(line []byte, current string) string {
+	fields := strings.Fields(string(line))
+	if len(fields) == 0 {
+		return current
+	}
+	if fields[0] == "#" {
+		if len(fields) >= 3 && (fields[1] == "HELP" || fields[1] == "TYPE") {
+			return fields[2]
+		}
+		return current
+	}
+
+	name := fields[0]
+	if i := strings.IndexByte(name, '{'); i >= 0 {
+		name = name[:i]
+	}
+	if current != "" {
+		for _, suffix := range []string{"", "_bucket", "_sum", "_count"} {
+			if name == current+suffix {
+				return current
+			}
+		}
+	}
+	return name
+}
+
+// limitedReader reads from r, returning errResponseTooLarge once more than
+// the given number of bytes is read.
+type limitedReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, errResponseTooLarge
+	}
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n, errResponseTooLarge
+	}
+	return n, err
+}
+
+// countingReader counts the number of bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
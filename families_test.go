@@ -0,0 +1,120 @@
+//
+// DISCLAIMER
+//
+// Copyright 2018 ArangoDB GmbH, Cologne, Germany
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Copyright holder is ArangoDB GmbH, Cologne, Germany
+//
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// TestParseMetrics tests the result of parseMetrics for various inputs.
+func TestParseMetrics(t *testing.T) {
+	input := `# HELP arangodb_guage_count Some guage
+# TYPE arangodb_guage_count guage
+arangodb_guage_count{label="guage"} 3
+# HELP arangodb_requests Requests
+# TYPE arangodb_requests counter
+arangodb_requests 7
+`
+	families, err := parseMetrics([]byte(input))
+	if err != nil {
+		t.Fatalf("parseMetrics failed: %v", err)
+	}
+	if len(families) != 2 {
+		t.Fatalf("parseMetrics returns unexpected #families: got %d, expected 2", len(families))
+	}
+
+	mf := families[0]
+	if mf.GetName() != "arangodb_guage_count" {
+		t.Errorf("parseMetrics returns unexpected name: got '%s'", mf.GetName())
+	}
+	if mf.GetType() != dto.MetricType_GAUGE {
+		t.Errorf("parseMetrics returns unexpected type: got %s, expected %s", mf.GetType(), dto.MetricType_GAUGE)
+	}
+	if mf.GetHelp() != "Some guage" {
+		t.Errorf("parseMetrics returns unexpected help: got '%s'", mf.GetHelp())
+	}
+	if v := mf.GetMetric()[0].GetLabel()[0].GetValue(); v != "guage" {
+		t.Errorf("parseMetrics returns unexpected label value: got '%s', expected 'guage'", v)
+	}
+
+	if _, err := parseMetrics([]byte("<html>Unauthorized</html>")); err == nil {
+		t.Errorf("parseMetrics succeeded for invalid input, expected an error")
+	}
+}
+
+// TestFamilyReader tests that familyReader splits metrics into one chunk per metric family.
+func TestFamilyReader(t *testing.T) {
+	input := `# HELP a_total Some counter
+# TYPE a_total counter
+a_total{x="1"} 1
+a_total{x="2"} 2
+# HELP b Some histogram
+# TYPE b histogram
+b_bucket{le="1"} 1
+b_bucket{le="+Inf"} 2
+b_sum 3
+b_count 2
+c 5
+d{y="a b"} 6`
+	expected := []string{
+		"# HELP a_total Some counter\n# TYPE a_total counter\na_total{x=\"1\"} 1\na_total{x=\"2\"} 2\n",
+		"# HELP b Some histogram\n# TYPE b histogram\nb_bucket{le=\"1\"} 1\nb_bucket{le=\"+Inf\"} 2\nb_sum 3\nb_count 2\n",
+		"c 5\n",
+		"d{y=\"a b\"} 6\n",
+	}
+
+	reader := newFamilyReader(bytes.NewReader([]byte(input)))
+	var chunks []string
+	for {
+		chunk, err := reader.next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("familyReader failed: %v", err)
+		}
+		chunks = append(chunks, string(chunk))
+	}
+	if !reflect.DeepEqual(chunks, expected) {
+		t.Errorf("familyReader returns unexpected chunks: got %q, expected %q", chunks, expected)
+	}
+}
+
+// TestLimitedReader tests that limitedReader fails once the limit is exceeded.
+func TestLimitedReader(t *testing.T) {
+	input := []byte("a 1\nb 2\n")
+
+	err := readFamilies(&limitedReader{r: bytes.NewReader(input), remaining: int64(len(input))}, func(*dto.MetricFamily) error { return nil })
+	if err != nil {
+		t.Errorf("readFamilies failed within limit: %v", err)
+	}
+
+	err = readFamilies(&limitedReader{r: bytes.NewReader(input), remaining: int64(len(input) - 1)}, func(*dto.MetricFamily) error { return nil })
+	if errors.Cause(err) != errResponseTooLarge {
+		t.Errorf("readFamilies returns unexpected error exceeding limit: got %v, expected %v", err, errResponseTooLarge)
+	}
+}
@@ -0,0 +1,77 @@
+//
+// DISCLAIMER
+//
+// Copyright 2018 ArangoDB GmbH, Cologne, Germany
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Copyright holder is ArangoDB GmbH, Cologne, Germany
+//
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// compileFilters compiles the given regular expressions, anchored so they must match entire metric names.
+func compileFilters(exprs []string) ([]*regexp.Regexp, error) {
+	result := make([]*regexp.Regexp, 0, len(exprs))
+	for _, expr := range exprs {
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("Invalid metrics filter '%s': %v", expr, err)
+		}
+		result = append(result, re)
+	}
+	return result, nil
+}
+
+// includeFamily returns true if a metric family with the given name passes the given filters.
+// When no include filters are given, all families are included unless excluded.
+func includeFamily(name string, include, exclude []*regexp.Regexp) bool {
+	if len(include) > 0 && !matchesAny(name, include) {
+		return false
+	}
+	return !matchesAny(name, exclude)
+}
+
+func matchesAny(name string, filters []*regexp.Regexp) bool {
+	for _, re := range filters {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// seriesCount returns the number of series in the given metric family.
+func seriesCount(mf *dto.MetricFamily) int {
+	count := 0
+	for _, m := range mf.GetMetric() {
+		switch mf.GetType() {
+		case dto.MetricType_HISTOGRAM:
+			// _bucket for every bucket, _sum & _count
+			count += len(m.GetHistogram().GetBucket()) + 2
+		case dto.MetricType_SUMMARY:
+			// Every quantile, _sum & _count
+			count += len(m.GetSummary().GetQuantile()) + 2
+		default:
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,63 @@
+//
+// DISCLAIMER
+//
+// Copyright 2018 ArangoDB GmbH, Cologne, Germany
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Copyright holder is ArangoDB GmbH, Cologne, Germany
+//
+
+package main
+
+import (
+	"testing"
+)
+
+// TestIncludeFamily tests the result of includeFamily for various filters.
+func TestIncludeFamily(t *testing.T) {
+	include, err := compileFilters([]string{"arangodb_client_.*", "arangodb_http_requests"})
+	if err != nil {
+		t.Fatalf("compileFilters failed: %v", err)
+	}
+	exclude, err := compileFilters([]string{".*_bucket_.*", "arangodb_client_user_.*"})
+	if err != nil {
+		t.Fatalf("compileFilters failed: %v", err)
+	}
+
+	tests := []struct {
+		Name     string
+		Included bool
+	}{
+		{"arangodb_client_connections", true},
+		{"arangodb_http_requests", true},
+		{"arangodb_http_requests_total", false},
+		{"arangodb_client_user_connections", false},
+		{"arangodb_client_bucket_x", false},
+		{"arangodb_scheduler_queue", false},
+	}
+
+	for i, test := range tests {
+		if result := includeFamily(test.Name, include, exclude); result != test.Included {
+			t.Errorf("includeFamily for test %d (%s) failed: got %v, expected %v", i, test.Name, result, test.Included)
+		}
+	}
+
+	if !includeFamily("anything", nil, nil) {
+		t.Errorf("includeFamily without filters failed: got false, expected true")
+	}
+
+	if _, err := compileFilters([]string{"("}); err == nil {
+		t.Errorf("compileFilters succeeded for invalid expression, expected an error")
+	}
+}
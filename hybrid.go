@@ -0,0 +1,164 @@
+//
+// DISCLAIMER
+//
+// Copyright 2018 ArangoDB GmbH, Cologne, Germany
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Copyright holder is ArangoDB GmbH, Cologne, Germany
+//
+
+package main
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/log"
+)
+
+var _ http.Handler = &hybrid{}
+
+// NewHybrid returns a handler that serves both the metrics calculated by the internal
+// exporter and the metrics provided by ArangoDB `_admin/metrics` in a single response.
+func NewHybrid(arangodbEndpoint string, auth Authentication, sslVerify bool, timeout time.Duration, passthruCfg PassthruConfig) (http.Handler, error) {
+	// Polling is only supported in internal & passthru mode
+	passthruCfg.Poll = PollConfig{}
+
+	// Both halves share the endpoint selected by the passthru, so they never mix the metrics of different servers
+	passthru := newPassthru(arangodbEndpoint, auth, sslVerify, timeout, passthruCfg)
+	exporter := newExporter(arangodbEndpoint, auth, sslVerify, timeout, nil, passthru.endpoints)
+	collisions := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "exporter_hybrid_collisions",
+		Help:      "Number of ArangoDB metric families dropped in the last scrape because their name collides with an internal metric.",
+	})
+	internal := prometheus.NewRegistry()
+	if err := internal.Register(collisions); err != nil {
+		return nil, maskAny(err)
+	}
+
+	return &hybrid{
+		exporter:   exporter,
+		source:     newScrapeSource(exporter, passthruCfg.CacheMaxAge),
+		internal:   internal,
+		passthru:   passthru,
+		collisions: collisions,
+	}, nil
+}
+
+type hybrid struct {
+	exporter   *Exporter
+	source     *scrapeSource
+	internal   prometheus.Gatherer
+	passthru   *passthru
+	collisions prometheus.Gauge
+}
+
+func (h *hybrid) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	ctx, cancel := scrapeContext(req)
+	defer cancel()
+
+	// Passthru failures must not hide the internal metrics, which report the failure through arangodb_up.
+	upstream, err := h.passthru.gatherContext(ctx)
+	if err != nil {
+		log.Errorf("Failed to gather ArangoDB metrics: %v", err)
+	}
+
+	exporter, err := h.source.gatherer(ctx)
+	if err != nil {
+		http.Error(resp, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	internal, err := prometheus.Gatherers{exporter, h.internal, h.passthru.metrics}.Gather()
+	if err != nil {
+		http.Error(resp, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	families, dropped := mergeFamilies(internal, upstream)
+	h.collisions.Set(float64(len(dropped)))
+	for _, name := range dropped {
+		log.Debugf("Dropped ArangoDB metric family %s, its name collides with an internal metric", name)
+	}
+
+	enc := newResponseEncoder(resp, req)
+	for _, mf := range families {
+		if err := enc.Encode(mf); err != nil {
+			log.Errorf("Failed to encode metrics: %v", err)
+			return
+		}
+	}
+	if err := enc.Close(); err != nil {
+		log.Errorf("Failed to encode metrics: %v", err)
+	}
+}
+
+// CloseIdleConnections closes the idle connections to ArangoDB of both halves.
+func (h *hybrid) CloseIdleConnections() {
+	h.exporter.CloseIdleConnections()
+	h.passthru.CloseIdleConnections()
+}
+
+// mergeFamilies merges the given internal and upstream metric families into a single list,
+// sorted by name. Upstream families with series names that collide with the series names
+// of an internal family are dropped, so existing dashboards keep working.
+// The names of the dropped families are returned.
+func mergeFamilies(internal, upstream []*dto.MetricFamily) ([]*dto.MetricFamily, []string) {
+	used := make(map[string]struct{})
+	for _, mf := range internal {
+		for _, name := range seriesNames(mf) {
+			used[name] = struct{}{}
+		}
+	}
+
+	result := make([]*dto.MetricFamily, 0, len(internal)+len(upstream))
+	result = append(result, internal...)
+
+	var dropped []string
+	for _, mf := range upstream {
+		collides := false
+		for _, name := range seriesNames(mf) {
+			if _, found := used[name]; found {
+				collides = true
+				break
+			}
+		}
+		if collides {
+			dropped = append(dropped, mf.GetName())
+			continue
+		}
+		result = append(result, mf)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].GetName() < result[j].GetName()
+	})
+	return result, dropped
+}
+
+// seriesNames returns the names of all series the given metric family can expose.
+func seriesNames(mf *dto.MetricFamily) []string {
+	name := mf.GetName()
+	switch mf.GetType() {
+	case dto.MetricType_HISTOGRAM:
+		return []string{name, name + "_bucket", name + "_sum", name + "_count"}
+	case dto.MetricType_SUMMARY:
+		return []string{name, name + "_sum", name + "_count"}
+	default:
+		return []string{name}
+	}
+}
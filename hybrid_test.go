@@ -0,0 +1,126 @@
+//
+// DISCLAIMER
+//
+// Copyright 2018 ArangoDB GmbH, Cologne, Germany
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Copyright holder is ArangoDB GmbH, Cologne, Germany
+//
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func family(name string, t dto.MetricType) *dto.MetricFamily {
+	return &dto.MetricFamily{
+		Name: proto.String(name),
+		Type: t.Enum(),
+	}
+}
+
+// TestMergeFamilies tests the result of mergeFamilies for various inputs.
+func TestMergeFamilies(t *testing.T) {
+	tests := []struct {
+		Internal []*dto.MetricFamily
+		Upstream []*dto.MetricFamily
+		Names    []string
+		Dropped  []string
+	}{
+		{
+			[]*dto.MetricFamily{family("b", dto.MetricType_GAUGE)},
+			[]*dto.MetricFamily{family("a", dto.MetricType_GAUGE), family("c", dto.MetricType_COUNTER)},
+			[]string{"a", "b", "c"},
+			nil,
+		},
+		{
+			[]*dto.MetricFamily{family("a", dto.MetricType_GAUGE)},
+			[]*dto.MetricFamily{family("a", dto.MetricType_COUNTER)},
+			[]string{"a"},
+			[]string{"a"},
+		},
+		{
+			[]*dto.MetricFamily{family("x_sum", dto.MetricType_GAUGE)},
+			[]*dto.MetricFamily{family("x", dto.MetricType_HISTOGRAM), family("y", dto.MetricType_SUMMARY)},
+			[]string{"x_sum", "y"},
+			[]string{"x"},
+		},
+		{
+			[]*dto.MetricFamily{family("a", dto.MetricType_GAUGE)},
+			nil,
+			[]string{"a"},
+			nil,
+		},
+	}
+
+	for i, test := range tests {
+		result, dropped := mergeFamilies(test.Internal, test.Upstream)
+		var names []string
+		for _, mf := range result {
+			names = append(names, mf.GetName())
+		}
+		if !reflect.DeepEqual(names, test.Names) {
+			t.Errorf("mergeFamilies for test %d returns unexpected families: got %v, expected %v", i, names, test.Names)
+		}
+		if !reflect.DeepEqual(dropped, test.Dropped) {
+			t.Errorf("mergeFamilies for test %d returns unexpected dropped families: got %v, expected %v", i, dropped, test.Dropped)
+		}
+	}
+}
+
+// TestHybridSharedEndpoints tests that both halves of the hybrid mode use the same endpoint,
+// which is exposed once.
+func TestHybridSharedEndpoints(t *testing.T) {
+	newServer := func(status int) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/_admin/server/availability" {
+				w.WriteHeader(status)
+				return
+			}
+			http.NotFound(w, r)
+		}))
+	}
+	follower, leader := newServer(http.StatusServiceUnavailable), newServer(http.StatusOK)
+	defer follower.Close()
+	defer leader.Close()
+
+	handler, err := NewHybrid(follower.URL+","+leader.URL, func() (string, error) { return "", nil }, false, time.Second, PassthruConfig{MetricsAPI: MetricsAPIV1})
+	if err != nil {
+		t.Fatalf("NewHybrid failed: %v", err)
+	}
+	h := handler.(*hybrid)
+	if h.exporter.endpoints != h.passthru.endpoints {
+		t.Fatal("Internal exporter and passthru use different endpoint selectors")
+	}
+	exporter, err := scrapeGatherer(context.Background(), h.exporter)
+	if err != nil {
+		t.Fatalf("scrapeGatherer failed: %v", err)
+	}
+	if _, err := (prometheus.Gatherers{exporter, h.passthru.metrics}).Gather(); err != nil {
+		t.Errorf("Gather failed: %v", err)
+	}
+	if endpoint := h.exporter.endpoints.currentEndpoint(); endpoint != leader.URL {
+		t.Errorf("Internal exporter uses %s, expected %s", endpoint, leader.URL)
+	}
+}
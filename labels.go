@@ -0,0 +1,134 @@
+//
+// DISCLAIMER
+//
+// Copyright 2018 ArangoDB GmbH, Cologne, Germany
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Copyright holder is ArangoDB GmbH, Cologne, Germany
+//
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/log"
+	"github.com/prometheus/common/model"
+)
+
+// parseLabels parses the given key=value pairs into labels.
+func parseLabels(pairs []string) (map[string]string, error) {
+	result := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Invalid label '%s', expected key=value", pair)
+		}
+		if !model.LabelName(parts[0]).IsValid() {
+			return nil, fmt.Errorf("Invalid label name '%s'", parts[0])
+		}
+		result[parts[0]] = parts[1]
+	}
+	return result, nil
+}
+
+// addLabels adds the given labels to all metrics of the given family.
+// Labels that are already set on a metric are left unchanged.
+func addLabels(mf *dto.MetricFamily, labels map[string]string) {
+	for _, m := range mf.GetMetric() {
+		existing := make(map[string]struct{}, len(m.GetLabel()))
+		for _, lp := range m.GetLabel() {
+			existing[lp.GetName()] = struct{}{}
+		}
+		for name, value := range labels {
+			if _, found := existing[name]; found {
+				continue
+			}
+			m.Label = append(m.Label, &dto.LabelPair{
+				Name:  proto.String(name),
+				Value: proto.String(value),
+			})
+		}
+		sort.Slice(m.Label, func(i, j int) bool {
+			return m.Label[i].GetName() < m.Label[j].GetName()
+		})
+	}
+}
+
+// identity learns the role & ID of a server, to be used as labels.
+// The identity is learned again after a reset, which happens when the server cannot be reached,
+// since the server may have been replaced.
+type identity struct {
+	getJSON func(ctx context.Context, path string, result interface{}) error
+
+	mutex  sync.Mutex
+	labels map[string]string
+	stale  bool
+}
+
+// get returns the identity labels of the server, learning them when needed.
+// When the identity cannot be learned, the last known identity is returned.
+func (i *identity) get(ctx context.Context) map[string]string {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	if i.labels != nil && !i.stale {
+		return i.labels
+	}
+
+	var role struct {
+		Role string `json:"role"`
+	}
+	if err := i.getJSON(ctx, "_admin/server/role", &role); err != nil {
+		log.Warnf("Failed to fetch server role: %v", err)
+		return i.labels
+	}
+	labels := map[string]string{
+		"role": roleLabel(role.Role),
+	}
+
+	// Only servers in a cluster have an ID
+	if labels["role"] != "single" {
+		var id struct {
+			ID string `json:"id"`
+		}
+		if err := i.getJSON(ctx, "_admin/server/id", &id); err != nil {
+			log.Warnf("Failed to fetch server ID: %v", err)
+			return i.labels
+		}
+		labels["server_id"] = id.ID
+	}
+
+	i.labels = labels
+	i.stale = false
+	return labels
+}
+
+// reset ensures the identity is learned again.
+func (i *identity) reset() {
+	if i == nil {
+		return
+	}
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	i.stale = true
+}
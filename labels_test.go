@@ -0,0 +1,62 @@
+//
+// DISCLAIMER
+//
+// Copyright 2018 ArangoDB GmbH, Cologne, Germany
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Copyright holder is ArangoDB GmbH, Cologne, Germany
+//
+
+package main
+
+import (
+	"testing"
+)
+
+// TestAddLabels tests that addLabels adds labels without overwriting existing ones.
+func TestAddLabels(t *testing.T) {
+	families, err := parseMetrics([]byte("a{role=\"agent\",x=\"1\"} 1\n"))
+	if err != nil {
+		t.Fatalf("parseMetrics failed: %v", err)
+	}
+	addLabels(families[0], map[string]string{"role": "dbserver", "deployment": "prod"})
+
+	expected := []string{"deployment=prod", "role=agent", "x=1"}
+	labels := families[0].GetMetric()[0].GetLabel()
+	if len(labels) != len(expected) {
+		t.Fatalf("addLabels results in unexpected #labels: got %d, expected %d", len(labels), len(expected))
+	}
+	for i, lp := range labels {
+		if result := lp.GetName() + "=" + lp.GetValue(); result != expected[i] {
+			t.Errorf("addLabels results in unexpected label %d: got '%s', expected '%s'", i, result, expected[i])
+		}
+	}
+}
+
+// TestParseLabels tests the result of parseLabels for various inputs.
+func TestParseLabels(t *testing.T) {
+	labels, err := parseLabels([]string{"env=prod", "region=eu=west"})
+	if err != nil {
+		t.Fatalf("parseLabels failed: %v", err)
+	}
+	if labels["env"] != "prod" || labels["region"] != "eu=west" {
+		t.Errorf("parseLabels returns unexpected labels: %v", labels)
+	}
+
+	for _, invalid := range []string{"env", "1env=prod", "=prod"} {
+		if _, err := parseLabels([]string{invalid}); err == nil {
+			t.Errorf("parseLabels succeeded for '%s', expected an error", invalid)
+		}
+	}
+}
@@ -23,13 +23,20 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	_ "net/http/pprof"
+	"os"
+	"os/signal"
+	"regexp"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/prometheus/common/log"
 	"github.com/prometheus/common/version"
 	"github.com/spf13/cobra"
@@ -40,6 +47,13 @@ type ExporterMode string
 const (
 	ModeInternal ExporterMode = "internal"
 	ModePassthru ExporterMode = "passthru"
+	ModeAuto     ExporterMode = "auto"
+	ModeHybrid   ExporterMode = "hybrid"
+)
+
+const (
+	// shutdownTimeout is the maximum time to wait for active requests when the exporter is stopped.
+	shutdownTimeout = time.Second * 10
 )
 
 var (
@@ -53,12 +67,34 @@ var (
 	}
 
 	serverOptions   ServerConfig
+	pollOptions     PollConfig
+	passthruOptions PassthruConfig
 	arangodbOptions struct {
-		endpoint  string
-		mode      string
-		jwtSecret string
-		jwtFile   string
-		timeout   time.Duration
+		endpoint   string
+		mode       string
+		jwtSecret  string
+		jwtFile    string
+		jwtRefresh time.Duration
+		timeout    time.Duration
+		discovery  bool
+	}
+	labelOptions struct {
+		labels     []string
+		deployment string
+	}
+	filterOptions struct {
+		include []string
+		exclude []string
+	}
+	cacheOptions struct {
+		maxAge time.Duration
+	}
+	collectorOptions struct {
+		timeouts []string
+	}
+	probeOptions struct {
+		enabled bool
+		targets []string
 	}
 )
 
@@ -68,12 +104,51 @@ func init() {
 	f.StringVar(&serverOptions.Address, "server.address", ":9101", "Address the exporter will listen on (IP:port)")
 	f.StringVar(&serverOptions.TLSKeyfile, "ssl.keyfile", "", "File containing TLS certificate used for the metrics server. Format equal to ArangoDB keyfiles")
 
-	f.StringVar(&arangodbOptions.endpoint, "arangodb.endpoint", "http://127.0.0.1:8529", "Endpoint used to reach the ArangoDB server")
+	f.StringVar(&arangodbOptions.endpoint, "arangodb.endpoint", "http://127.0.0.1:8529", "Endpoint used to reach the ArangoDB server. Multiple endpoints can be given as comma separated list, the first available endpoint is used (the leader in Active Failover) and another one after a failed scrape")
 	f.StringVar(&arangodbOptions.jwtSecret, "arangodb.jwtsecret", "", "JWT Secret used for authentication with ArangoDB server")
 	f.StringVar(&arangodbOptions.jwtFile, "arangodb.jwt-file", "", "File containing the JWT for authentication with ArangoDB server")
 	f.DurationVar(&arangodbOptions.timeout, "arangodb.timeout", time.Second*15, "Timeout of statistics requests for ArangoDB")
+	f.DurationVar(&arangodbOptions.jwtRefresh, "arangodb.jwt-refresh", time.Minute, "Interval at which the JWT used for authentication with ArangoDB server is refreshed")
+	f.BoolVar(&arangodbOptions.discovery, "arangodb.discovery", false, "Discover all members of the cluster the coordinator at --arangodb.endpoint belongs to and collect the statistics of each of them (internal mode only)")
+
+	f.IntVar(&retryOptions.Retries, "arangodb.retries", retryOptions.Retries, "Number of times a failed request to ArangoDB is retried, as long as the scrape deadline allows")
+	f.DurationVar(&retryOptions.Backoff, "arangodb.retry-backoff", retryOptions.Backoff, "Delay before the first retry of a failed request to ArangoDB, doubled for every next retry")
+	f.IntVar(&retryOptions.BreakerThreshold, "arangodb.breaker-threshold", retryOptions.BreakerThreshold, "Number of consecutive failed requests after which no requests are sent to ArangoDB until --arangodb.breaker-cooldown has passed. 0 disables the circuit breaker")
+	f.DurationVar(&retryOptions.BreakerCooldown, "arangodb.breaker-cooldown", retryOptions.BreakerCooldown, "Time after which a request is sent to ArangoDB again, once the circuit breaker is open")
+	f.IntVar(&passthruOptions.Transport.MaxIdleConns, "arangodb.max-idle-conns", 4, "Maximum number of idle (keep-alive) connections to ArangoDB server in passthru mode")
+	f.DurationVar(&passthruOptions.Transport.IdleConnTimeout, "arangodb.idle-conn-timeout", time.Second*90, "Time after which idle connections to ArangoDB server are closed in passthru mode")
+	f.DurationVar(&passthruOptions.Transport.KeepAlive, "arangodb.keep-alive", time.Second*30, "Interval of TCP keep-alive probes on connections to ArangoDB server in passthru mode")
+	f.BoolVar(&passthruOptions.Transport.HTTP2, "arangodb.http2", true, "Use HTTP/2 for TLS connections to ArangoDB server in passthru mode, when supported by the server")
+	f.Int64Var(&passthruOptions.MaxBodySize, "passthru.max-body-size", 64*1024*1024, "Maximum size in bytes of the ArangoDB metrics response in passthru mode, 0 for unlimited")
+	f.StringVar(&passthruOptions.MetricsAPI, "passthru.metrics-api", MetricsAPIAuto, "Metrics API of ArangoDB used in passthru mode. Auto - use _admin/metrics/v2 for ArangoDB >= 3.8.0 and _admin/metrics otherwise. v1 - use _admin/metrics. v2 - use _admin/metrics/v2")
+	f.BoolVar(&passthruOptions.ProxyDBServers, "passthru.proxy-dbservers", false, "Add the metrics of all DB-Servers of the cluster, fetched through the coordinator, in passthru mode")
+	f.BoolVar(&passthruOptions.ErrorsAsDown, "passthru.errors-as-down", false, "Respond to failed scrapes in passthru mode with status 200 and arangodb_up 0, like the internal mode, instead of an error status")
+
+	f.StringArrayVar(&labelOptions.labels, "passthru.label", nil, "Label (key=value) added to all metrics in passthru mode. Can be specified multiple times")
+	f.StringVar(&labelOptions.deployment, "passthru.deployment", "", "Name of the deployment, added as deployment label to all metrics in passthru mode")
+	f.BoolVar(&passthruOptions.IdentityLabels, "passthru.identity-labels", false, "Add role and server_id labels, learned from the ArangoDB server, to all metrics in passthru mode")
+	f.StringArrayVar(&filterOptions.include, "metrics.include", nil, "Regular expression matching the names of metrics exposed in passthru mode. Can be specified multiple times, all metrics are exposed if not specified")
+	f.StringArrayVar(&filterOptions.exclude, "metrics.exclude", nil, "Regular expression matching the names of metrics not exposed in passthru mode. Can be specified multiple times")
+	f.BoolVar(&enableOpenMetrics, "metrics.openmetrics", false, "Respond in the OpenMetrics text format when the scraper accepts it. Counters without _total suffix are exposed with that suffix in this format, which changes their series names")
+
+	f.BoolVar(&probeOptions.enabled, "probe.enabled", false, "Serve the /probe endpoint, which exposes the metrics of the ArangoDB server given in its target parameter")
+	f.StringArrayVar(&probeOptions.targets, "probe.allowed-targets", nil, "Regular expression matching the endpoints (e.g. https://db.example.com:8529) that may be probed. The authentication of the exporter is sent to these endpoints. Can be specified multiple times, required with --probe.enabled")
+
+	f.DurationVar(&cacheOptions.maxAge, "cache.max-age", 0, "Maximum age of cached ArangoDB metrics. Scrapes are served from the cache and concurrent scrapes are merged into a single request to ArangoDB. 0 disables the cache")
+
+	f.DurationVar(&pollOptions.Interval, "poll.interval", 0, "Interval at which ArangoDB is polled in the background, scrapes are served from the last poll. 0 disables polling. Supported in internal and passthru mode")
+	f.DurationVar(&pollOptions.StaleAfter, "poll.stale-after", 0, "Age after which polled metrics are no longer served and ArangoDB is reported as down. Defaults to three times the poll interval")
+
+	f.StringArrayVar(&collectorOptions.timeouts, "collector.timeout", nil, "Timeout (name=duration) of a collector in internal mode, defaults to --arangodb.timeout. Collectors are "+strings.Join(collectorNames, ", ")+". Can be specified multiple times")
+	f.DurationVar(&scrapeTimeoutOffset, "scrape.timeout-offset", scrapeTimeoutOffset, "Time subtracted from the scrape timeout sent by Prometheus in the "+scrapeTimeoutHeader+" header, to leave time to respond before Prometheus gives up. Requests to ArangoDB are aborted once the remaining time has passed")
+	for _, name := range collectorNames {
+		f.BoolVar(collectorEnabled[name], "collector."+name, *collectorEnabled[name], "Enable the "+name+" collector in internal mode")
+	}
+	f.BoolVar(&afLeaderOnly, "activefailover.leader-only", false, "Only expose the metrics of the Active Failover collector when the server is a follower in internal mode. Enables the activefailover collector")
+	f.DurationVar(&descriptionTTL, "internal.description-ttl", descriptionTTL, "Time after which the statistics description is fetched again in internal mode. It is also fetched again when the version or ID of the server changes")
+	f.BoolVar(&legacyMetricTypes, "internal.legacy-metric-types", false, "Expose accumulated figures as gauges and distributions as separate _sum, _count and _bucket gauges in internal mode, as done by previous versions")
 
-	f.StringVar(&arangodbOptions.mode, "mode", "internal", "Mode for ArangoDB exporter. Internal - use internal, old mode of metrics calculation (default). Passthru - expose ArangoD metrics directly, using proper authentication.")
+	f.StringVar(&arangodbOptions.mode, "mode", "internal", "Mode for ArangoDB exporter. Internal - use internal, old mode of metrics calculation (default). Passthru - expose ArangoD metrics directly, using proper authentication. Auto - use passthru for ArangoDB >= 3.6.0 and internal otherwise, detected from the server version. Hybrid - expose both internal and ArangoD metrics.")
 
 	f.MarkDeprecated("arangodb.jwtsecret", "please use --arangodb.jwt-file instead")
 }
@@ -84,25 +159,111 @@ func main() {
 
 func cmdMainRun(cmd *cobra.Command, args []string) {
 	log.Infoln(fmt.Sprintf("Starting arangodb-exporter %s, build %s", projectVersion, projectBuild))
+	version.Version = projectVersion
+	version.Revision = projectBuild
 
+	labels, err := parseLabels(labelOptions.labels)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if labelOptions.deployment != "" {
+		labels["deployment"] = labelOptions.deployment
+	}
+	passthruOptions.Labels = labels
+	passthruOptions.CacheMaxAge = cacheOptions.maxAge
+	passthruOptions.Poll = pollOptions
+
+	if !isValidMetricsAPI(passthruOptions.MetricsAPI) {
+		log.Fatalf("Invalid metrics API '%s', expected one of %s, %s or %s", passthruOptions.MetricsAPI, MetricsAPIAuto, MetricsAPIV1, MetricsAPIV2)
+	}
+
+	if collectorTimeouts, err = parseCollectorTimeouts(collectorOptions.timeouts); err != nil {
+		log.Fatal(err)
+	}
+	if afLeaderOnly {
+		*collectorEnabled[collectorActiveFailover] = true
+	}
+
+	if passthruOptions.Include, err = compileFilters(filterOptions.include); err != nil {
+		log.Fatal(err)
+	}
+	if passthruOptions.Exclude, err = compileFilters(filterOptions.exclude); err != nil {
+		log.Fatal(err)
+	}
+
+	var probeTargets []*regexp.Regexp
+	if pollOptions.Interval > 0 && cacheOptions.maxAge > 0 {
+		log.Fatal("--poll.interval and --cache.max-age cannot be combined")
+	}
+	if mode := ExporterMode(arangodbOptions.mode); pollOptions.Interval > 0 && (mode == ModeAuto || mode == ModeHybrid) {
+		log.Fatalf("--poll.interval is not supported in %s mode", mode)
+	}
+
+	if probeOptions.enabled {
+		if len(probeOptions.targets) == 0 {
+			log.Fatal("--probe.allowed-targets is required with --probe.enabled")
+		}
+		if probeTargets, err = compileProbeTargets(probeOptions.targets); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	auth := newCachedAuthentication(newAuthentication(), arangodbOptions.jwtRefresh)
+
+	var stoppers []stopper
 	mux := http.NewServeMux()
 	switch ExporterMode(arangodbOptions.mode) {
 	case ModePassthru:
-		passthru, err := NewPassthru(arangodbOptions.endpoint, newAuthentication(), false, arangodbOptions.timeout)
+		passthru, err := NewPassthru(arangodbOptions.endpoint, auth, false, arangodbOptions.timeout, passthruOptions)
 		if err != nil {
 			log.Fatal(err)
 		}
+		if s, ok := passthru.(stopper); ok {
+			stoppers = append(stoppers, s)
+		}
 		mux.Handle("/metrics", passthru)
+	case ModeAuto:
+		auto, err := NewAutoMode(arangodbOptions.endpoint, auth, false, arangodbOptions.timeout, passthruOptions)
+		if err != nil {
+			log.Fatal(err)
+		}
+		mux.Handle("/metrics", auto)
+	case ModeHybrid:
+		hybrid, err := NewHybrid(arangodbOptions.endpoint, auth, false, arangodbOptions.timeout, passthruOptions)
+		if err != nil {
+			log.Fatal(err)
+		}
+		mux.Handle("/metrics", hybrid)
 	default:
-		exporter, err := NewExporter(arangodbOptions.endpoint, newAuthentication(), false, arangodbOptions.timeout)
+		var exporter contextCollector
+		var err error
+		if arangodbOptions.discovery {
+			exporter, err = NewClusterExporter(arangodbOptions.endpoint, auth, false, arangodbOptions.timeout)
+		} else {
+			exporter, err = NewExporter(arangodbOptions.endpoint, auth, false, arangodbOptions.timeout)
+		}
 		if err != nil {
 			log.Fatal(err)
 		}
-		prometheus.MustRegister(exporter)
-		version.Version = projectVersion
-		version.Revision = projectBuild
 		prometheus.MustRegister(version.NewCollector("arangodb_exporter"))
-		mux.Handle("/metrics", prometheus.Handler())
+		if pollOptions.Interval > 0 {
+			registry := prometheus.NewRegistry()
+			registry.MustRegister(exporter)
+			poller := newPoller(registry, pollOptions)
+			stoppers = append(stoppers, poller)
+			prometheus.MustRegister(poller.ageCollector())
+			gatherers := prometheus.Gatherers{poller, prometheus.DefaultGatherer}
+			// A stale snapshot still reports arangodb_up 0
+			opts := promhttp.HandlerOpts{ErrorHandling: promhttp.ContinueOnError}
+			mux.Handle("/metrics", prometheus.InstrumentHandler("prometheus", promhttp.HandlerFor(gatherers, opts)))
+		} else {
+			source := newScrapeSource(exporter, cacheOptions.maxAge)
+			mux.Handle("/metrics", prometheus.InstrumentHandler("prometheus", newScrapeHandler(source, prometheus.DefaultGatherer)))
+		}
+	}
+
+	if probeOptions.enabled {
+		mux.Handle("/probe", NewProbe(ExporterMode(arangodbOptions.mode), auth, false, arangodbOptions.timeout, passthruOptions, probeTargets))
 	}
 
 	log.Infoln("Listening on", serverOptions.Address)
@@ -121,5 +282,24 @@ func cmdMainRun(cmd *cobra.Command, args []string) {
 	if err != nil {
 		log.Fatal(err)
 	}
-	log.Fatal(server.Run())
+
+	// Stop gracefully on SIGINT & SIGTERM, so scrapes in progress are completed
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+		log.Infoln("Shutting down")
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Errorf("Failed to shut down gracefully: %v", err)
+		}
+	}()
+
+	if err := server.Run(); err != nil {
+		log.Fatal(err)
+	}
+	for _, s := range stoppers {
+		s.stop()
+	}
 }
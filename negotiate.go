@@ -0,0 +1,303 @@
+//
+// DISCLAIMER
+//
+// Copyright 2018 ArangoDB GmbH, Cologne, Germany
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Copyright holder is ArangoDB GmbH, Cologne, Germany
+//
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+const (
+	openMetricsType = "application/openmetrics-text"
+	// FmtOpenMetrics is the OpenMetrics text format, which is not supported by expfmt.
+	FmtOpenMetrics expfmt.Format = openMetricsType + "; version=0.0.1; charset=utf-8"
+)
+
+// enableOpenMetrics enables the OpenMetrics text format. It is disabled by default,
+// since counters without _total suffix are renamed in OpenMetrics output and
+// Prometheus prefers OpenMetrics when it is offered.
+var enableOpenMetrics = false
+
+// negotiate returns the metrics format to use for the given request headers,
+// taking the quality of the accepted media types into account.
+// Next to the formats of expfmt, the OpenMetrics text format is supported when enabled.
+func negotiate(h http.Header) expfmt.Format {
+	type accept struct {
+		mediaType string
+		params    map[string]string
+		q         float64
+	}
+	var accepts []accept
+	for _, part := range strings.Split(h.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, found := params["q"]; found {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		accepts = append(accepts, accept{mediaType: mediaType, params: params, q: q})
+	}
+	sort.SliceStable(accepts, func(i, j int) bool {
+		return accepts[i].q > accepts[j].q
+	})
+
+	for _, ac := range accepts {
+		switch ac.mediaType {
+		case openMetricsType:
+			if !enableOpenMetrics {
+				continue
+			}
+			if v := ac.params["version"]; v == "1.0.0" {
+				return openMetricsType + "; version=1.0.0; charset=utf-8"
+			}
+			return FmtOpenMetrics
+		case expfmt.ProtoType:
+			if ac.params["proto"] != expfmt.ProtoProtocol {
+				continue
+			}
+			switch ac.params["encoding"] {
+			case "delimited":
+				return expfmt.FmtProtoDelim
+			case "text":
+				return expfmt.FmtProtoText
+			case "compact-text":
+				return expfmt.FmtProtoCompact
+			}
+		case "text/plain":
+			if v := ac.params["version"]; v == expfmt.TextVersion || v == "" {
+				return expfmt.FmtText
+			}
+		}
+	}
+	return expfmt.FmtText
+}
+
+// metricsEncoder encodes metric families in a specific format.
+type metricsEncoder interface {
+	expfmt.Encoder
+	// Close completes the encoded metrics.
+	Close() error
+}
+
+// newResponseEncoder prepares the given response for metrics in the format negotiated
+// with the given request, compressed with gzip when the request accepts it.
+// The returned encoder must be closed to complete the response.
+func newResponseEncoder(resp http.ResponseWriter, req *http.Request) metricsEncoder {
+	format := negotiate(req.Header)
+	resp.Header().Set("Content-Type", string(format))
+
+	var w io.Writer = resp
+	var gz *gzip.Writer
+	if acceptsGzip(req.Header) {
+		resp.Header().Set("Content-Encoding", "gzip")
+		resp.Header().Add("Vary", "Accept-Encoding")
+		gz = gzip.NewWriter(resp)
+		w = gz
+	}
+
+	var enc metricsEncoder
+	if strings.HasPrefix(string(format), openMetricsType) {
+		enc = &openMetricsEncoder{w: w}
+	} else {
+		enc = nopCloseEncoder{expfmt.NewEncoder(w, format)}
+	}
+	if gz != nil {
+		return gzipEncoder{metricsEncoder: enc, gz: gz}
+	}
+	return enc
+}
+
+// acceptsGzip returns true if the given request headers accept gzip encoded responses.
+func acceptsGzip(h http.Header) bool {
+	for _, part := range strings.Split(h.Get("Accept-Encoding"), ",") {
+		params := strings.Split(part, ";")
+		if strings.TrimSpace(params[0]) != "gzip" {
+			continue
+		}
+		for _, param := range params[1:] {
+			kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if len(kv) == 2 && kv[0] == "q" {
+				if q, err := strconv.ParseFloat(kv[1], 64); err == nil && q == 0 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
+
+type nopCloseEncoder struct {
+	expfmt.Encoder
+}
+
+func (nopCloseEncoder) Close() error {
+	return nil
+}
+
+type gzipEncoder struct {
+	metricsEncoder
+	gz *gzip.Writer
+}
+
+func (e gzipEncoder) Close() error {
+	if err := e.metricsEncoder.Close(); err != nil {
+		return err
+	}
+	return e.gz.Close()
+}
+
+// openMetricsEncoder encodes metric families in the OpenMetrics text format.
+type openMetricsEncoder struct {
+	w io.Writer
+}
+
+// Encode writes the given metric family.
+func (e *openMetricsEncoder) Encode(mf *dto.MetricFamily) error {
+	var buf bytes.Buffer
+	name := mf.GetName()
+	metricType := "unknown"
+	switch mf.GetType() {
+	case dto.MetricType_COUNTER:
+		metricType = "counter"
+		// OpenMetrics counters are named without the _total suffix of their samples
+		name = strings.TrimSuffix(name, "_total")
+	case dto.MetricType_GAUGE:
+		metricType = "gauge"
+	case dto.MetricType_HISTOGRAM:
+		metricType = "histogram"
+	case dto.MetricType_SUMMARY:
+		metricType = "summary"
+	}
+
+	fmt.Fprintf(&buf, "# TYPE %s %s\n", name, metricType)
+	if mf.Help != nil {
+		fmt.Fprintf(&buf, "# HELP %s %s\n", name, escapeOpenMetrics(mf.GetHelp()))
+	}
+
+	for _, m := range mf.GetMetric() {
+		switch mf.GetType() {
+		case dto.MetricType_COUNTER:
+			writeOpenMetricsSample(&buf, name+"_total", m, "", 0, m.GetCounter().GetValue())
+		case dto.MetricType_GAUGE:
+			writeOpenMetricsSample(&buf, name, m, "", 0, m.GetGauge().GetValue())
+		case dto.MetricType_HISTOGRAM:
+			h := m.GetHistogram()
+			hasInf := false
+			for _, b := range h.GetBucket() {
+				if math.IsInf(b.GetUpperBound(), +1) {
+					hasInf = true
+				}
+				writeOpenMetricsSample(&buf, name+"_bucket", m, "le", b.GetUpperBound(), float64(b.GetCumulativeCount()))
+			}
+			if !hasInf {
+				writeOpenMetricsSample(&buf, name+"_bucket", m, "le", math.Inf(+1), float64(h.GetSampleCount()))
+			}
+			writeOpenMetricsSample(&buf, name+"_count", m, "", 0, float64(h.GetSampleCount()))
+			writeOpenMetricsSample(&buf, name+"_sum", m, "", 0, h.GetSampleSum())
+		case dto.MetricType_SUMMARY:
+			s := m.GetSummary()
+			for _, q := range s.GetQuantile() {
+				writeOpenMetricsSample(&buf, name, m, "quantile", q.GetQuantile(), q.GetValue())
+			}
+			writeOpenMetricsSample(&buf, name+"_count", m, "", 0, float64(s.GetSampleCount()))
+			writeOpenMetricsSample(&buf, name+"_sum", m, "", 0, s.GetSampleSum())
+		default:
+			writeOpenMetricsSample(&buf, name, m, "", 0, m.GetUntyped().GetValue())
+		}
+	}
+
+	_, err := e.w.Write(buf.Bytes())
+	return err
+}
+
+// Close writes the EOF marker that completes OpenMetrics output.
+func (e *openMetricsEncoder) Close() error {
+	_, err := io.WriteString(e.w, "# EOF\n")
+	return err
+}
+
+// writeOpenMetricsSample writes a single sample of the given metric.
+// When extraLabel is set, it is added with the given float value (used for le & quantile).
+func writeOpenMetricsSample(buf *bytes.Buffer, name string, m *dto.Metric, extraLabel string, extraValue float64, value float64) {
+	buf.WriteString(name)
+	labels := m.GetLabel()
+	if len(labels) > 0 || extraLabel != "" {
+		buf.WriteByte('{')
+		for i, lp := range labels {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			fmt.Fprintf(buf, "%s=\"%s\"", lp.GetName(), escapeOpenMetrics(lp.GetValue()))
+		}
+		if extraLabel != "" {
+			if len(labels) > 0 {
+				buf.WriteByte(',')
+			}
+			fmt.Fprintf(buf, "%s=\"%s\"", extraLabel, formatOpenMetricsFloat(extraValue))
+		}
+		buf.WriteByte('}')
+	}
+	buf.WriteByte(' ')
+	buf.WriteString(formatOpenMetricsFloat(value))
+	if m.TimestampMs != nil {
+		// OpenMetrics timestamps are in seconds
+		buf.WriteByte(' ')
+		buf.WriteString(strconv.FormatFloat(float64(m.GetTimestampMs())/1000, 'f', -1, 64))
+	}
+	buf.WriteByte('\n')
+}
+
+var openMetricsEscaper = strings.NewReplacer("\\", `\\`, "\n", `\n`, "\"", `\"`)
+
+// escapeOpenMetrics escapes the given label value or HELP text.
+func escapeOpenMetrics(s string) string {
+	return openMetricsEscaper.Replace(s)
+}
+
+// formatOpenMetricsFloat formats the given value as OpenMetrics number.
+func formatOpenMetricsFloat(f float64) string {
+	switch {
+	case math.IsInf(f, +1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+}
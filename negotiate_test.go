@@ -0,0 +1,123 @@
+//
+// DISCLAIMER
+//
+// Copyright 2018 ArangoDB GmbH, Cologne, Germany
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Copyright holder is ArangoDB GmbH, Cologne, Germany
+//
+
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	"github.com/prometheus/common/expfmt"
+)
+
+// TestNegotiate tests the format selected for various Accept headers.
+func TestNegotiate(t *testing.T) {
+	defer func() { enableOpenMetrics = false }()
+	tests := []struct {
+		Accept      string
+		OpenMetrics bool
+		Format      expfmt.Format
+	}{
+		{"", false, expfmt.FmtText},
+		{"text/plain", false, expfmt.FmtText},
+		{"text/plain;version=0.0.4;q=0.5,*/*;q=0.1", false, expfmt.FmtText},
+		{"application/openmetrics-text; version=0.0.1", true, FmtOpenMetrics},
+		{"application/openmetrics-text;version=0.0.1;q=0.5,text/plain;version=0.0.4;q=0.9", true, expfmt.FmtText},
+		{"application/openmetrics-text;version=1.0.0", true, "application/openmetrics-text; version=1.0.0; charset=utf-8"},
+		{"application/openmetrics-text; version=0.0.1", false, expfmt.FmtText},
+		{"application/openmetrics-text;version=1.0.0;q=0.9,text/plain;version=0.0.4;q=0.5", false, expfmt.FmtText},
+		{"application/vnd.google.protobuf;proto=io.prometheus.client.MetricFamily;encoding=delimited", false, expfmt.FmtProtoDelim},
+		{"application/vnd.google.protobuf;proto=other;encoding=delimited", false, expfmt.FmtText},
+		{"application/json", false, expfmt.FmtText},
+	}
+
+	for i, test := range tests {
+		enableOpenMetrics = test.OpenMetrics
+		h := http.Header{}
+		h.Set("Accept", test.Accept)
+		if result := negotiate(h); result != test.Format {
+			t.Errorf("negotiate for test %d (%s) failed: got %s, expected %s", i, test.Accept, result, test.Format)
+		}
+	}
+}
+
+// TestAcceptsGzip tests the result of acceptsGzip for various Accept-Encoding headers.
+func TestAcceptsGzip(t *testing.T) {
+	tests := []struct {
+		AcceptEncoding string
+		Accepted       bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"deflate, gzip;q=0.5", true},
+		{"gzip;q=0", false},
+		{"identity", false},
+	}
+
+	for i, test := range tests {
+		h := http.Header{}
+		h.Set("Accept-Encoding", test.AcceptEncoding)
+		if result := acceptsGzip(h); result != test.Accepted {
+			t.Errorf("acceptsGzip for test %d (%s) failed: got %v, expected %v", i, test.AcceptEncoding, result, test.Accepted)
+		}
+	}
+}
+
+// TestOpenMetricsEncoder tests the OpenMetrics output for parsed metrics.
+func TestOpenMetricsEncoder(t *testing.T) {
+	families, err := parseMetrics([]byte(`# HELP arangodb_requests_total Number of requests.
+# TYPE arangodb_requests_total counter
+arangodb_requests_total{role="single"} 7
+# TYPE arangodb_latency histogram
+arangodb_latency_bucket{le="0.5"} 1
+arangodb_latency_bucket{le="+Inf"} 2
+arangodb_latency_sum 1.5
+arangodb_latency_count 2
+`))
+	if err != nil {
+		t.Fatalf("parseMetrics failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	enc := &openMetricsEncoder{w: &buf}
+	for _, mf := range families {
+		if err := enc.Encode(mf); err != nil {
+			t.Fatalf("Encode failed: %v", err)
+		}
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	expected := `# TYPE arangodb_latency histogram
+arangodb_latency_bucket{le="0.5"} 1
+arangodb_latency_bucket{le="+Inf"} 2
+arangodb_latency_count 2
+arangodb_latency_sum 1.5
+# TYPE arangodb_requests counter
+# HELP arangodb_requests Number of requests.
+arangodb_requests_total{role="single"} 7
+# EOF
+`
+	if result := buf.String(); result != expected {
+		t.Errorf("Encode failed: got\n%s\nexpected\n%s", result, expected)
+	}
+}
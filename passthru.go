@@ -23,110 +23,710 @@
 package main
 
 import (
+	"compress/gzip"
+	"context"
 	"crypto/tls"
+	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"mime"
+	"net"
 	"net/http"
+	"regexp"
 	"strings"
+	"sync/atomic"
 	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/log"
+	"github.com/prometheus/common/version"
+)
+
+const (
+	// maxDrainSize is the maximum number of bytes read from an unused response body to reuse its connection.
+	maxDrainSize = 64 * 1024
+	// maxJSONSize is the maximum number of bytes read from a JSON response of ArangoDB,
+	// e.g. the cluster health, which grows with the number of servers in the cluster.
+	maxJSONSize = 16 * 1024 * 1024
+)
+
+// Reasons of failed passthru scrapes, used as label of the scrape errors metric.
+const (
+	scrapeErrorRequest     = "request"      // The request could not be created, e.g. because the JWT is not available
+	scrapeErrorConnection  = "connection"   // ArangoDB could not be reached
+	scrapeErrorStatus      = "status"       // ArangoDB responded with an error status
+	scrapeErrorContentType = "content_type" // ArangoDB responded with something else than metrics
+	scrapeErrorOversized   = "oversized"    // The response exceeds the maximum body size
+	scrapeErrorRead        = "read"         // The response could not be read or forwarded completely
+	scrapeErrorParse       = "parse"        // (Part of) the response could not be parsed
 )
 
+var scrapeErrorReasons = []string{
+	scrapeErrorRequest, scrapeErrorConnection, scrapeErrorStatus, scrapeErrorContentType,
+	scrapeErrorOversized, scrapeErrorRead, scrapeErrorParse,
+}
+
 var _ http.Handler = &passthru{}
+var _ prometheus.Gatherer = &passthru{}
 
-func NewPassthru(arangodbEndpoint string, auth Authentication, sslVerify bool, timeout time.Duration) (http.Handler, error) {
-	return &passthru{
-		factory: newHttpClientFactory(arangodbEndpoint, auth, sslVerify, timeout),
-	}, nil
+// PassthruConfig settings for the passthru mode
+type PassthruConfig struct {
+	Transport      TransportConfig   // Settings of the HTTP transport used to reach ArangoDB
+	MaxBodySize    int64             // Maximum size of the metrics response of ArangoDB in bytes, 0 for unlimited
+	Labels         map[string]string // Labels added to all metrics
+	IdentityLabels bool              // Add role & server_id labels, learned from the server, to all metrics
+	Include        []*regexp.Regexp  // Only metric families with a name matching one of these are exposed, all if empty
+	Exclude        []*regexp.Regexp  // Metric families with a name matching one of these are not exposed
+	ErrorsAsDown   bool              // Respond to failed scrapes with status 200 and arangodb_up 0, instead of an error status
+	MetricsAPI     string            // Version of the metrics API of ArangoDB: auto, v1 or v2
+	ProxyDBServers bool              // Add the metrics of all DB-Servers, fetched through the coordinator
+	CacheMaxAge    time.Duration     // Maximum age of cached metrics, 0 disables the cache
+	Poll           PollConfig        // Settings for polling the metrics in the background
 }
 
-type httpClientFactory func() (*http.Client, *http.Request, error)
+// TransportConfig settings for the HTTP transport used to reach ArangoDB
+type TransportConfig struct {
+	MaxIdleConns    int           // Maximum number of idle (keep-alive) connections
+	IdleConnTimeout time.Duration // Time after which idle connections are closed
+	KeepAlive       time.Duration // Interval of TCP keep-alive probes
+	HTTP2           bool          // Use HTTP/2 when the server supports it
+}
 
-func newHttpClientFactory(arangodbEndpoint string, auth Authentication, sslVerify bool, timeout time.Duration) httpClientFactory {
-	return func() (*http.Client, *http.Request, error) {
-		transport := &http.Transport{}
+func NewPassthru(arangodbEndpoint string, auth Authentication, sslVerify bool, timeout time.Duration, cfg PassthruConfig) (http.Handler, error) {
+	return newPassthru(arangodbEndpoint, auth, sslVerify, timeout, cfg), nil
+}
 
-		req, err := http.NewRequest("GET", fmt.Sprintf("%s/_admin/metrics", arangodbEndpoint), nil)
-		if err != nil {
-			return nil, nil, maskAny(err)
-		}
+func newPassthru(arangodbEndpoint string, auth Authentication, sslVerify bool, timeout time.Duration, cfg PassthruConfig) *passthru {
+	p := &passthru{
+		client:  newHttpClient(sslVerify, timeout, cfg.Transport),
+		metrics: prometheus.NewRegistry(),
+		scrape:  prometheus.NewRegistry(),
+		up: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "up",
+			Help:      "Was the last scrape of ArangoDB successful.",
+		}),
+		totalScrapes: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "exporter_total_scrapes",
+			Help:      "Current total ArangoDB scrapes.",
+		}),
+		failedScrapes: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "exporter_failed_scrapes",
+			Help:      "Number of failed ArangoDB scrapes",
+		}),
+		scrapeDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "exporter_passthru_scrape_duration_seconds",
+			Help:      "Duration of ArangoDB metrics scrapes in seconds.",
+			Buckets:   prometheus.DefBuckets,
+		}),
+		scrapeErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "exporter_passthru_scrape_errors",
+			Help:      "Number of failed ArangoDB metrics scrapes by reason.",
+		}, []string{"reason"}),
+		upstreamBytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "exporter_passthru_upstream_bytes",
+			Help:      "Number of bytes of ArangoDB metrics responses read, before decompression.",
+		}),
+		errorsAsDown:   cfg.ErrorsAsDown,
+		proxyDBServers: cfg.ProxyDBServers,
+		proxyUp: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "exporter_passthru_proxy_up",
+			Help:      "Was the last scrape of the DB-Server through the coordinator successful.",
+		}, []string{"server_id"}),
+		upstreamStatus: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "exporter_passthru_upstream_status",
+			Help:      "HTTP status code of the last ArangoDB metrics response, 0 if ArangoDB could not be reached.",
+		}),
+		parseError: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "exporter_passthru_parse_error",
+			Help:      "Was the last response of ArangoDB metrics not parseable.",
+		}),
+		splitFamilies: new(uint32),
+		maxBodySize:   cfg.MaxBodySize,
+		extraLabels:   cfg.Labels,
+		include:       cfg.Include,
+		exclude:       cfg.Exclude,
+		filtered: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "exporter_filtered_series",
+			Help:      "Number of ArangoDB series dropped by the metrics include & exclude filters.",
+		}),
+	}
+	p.transport = newRetryTransport(p.client.Transport, retryOptions, "passthru", nil)
+	p.client.Transport = p.transport
+	// The selector is shared with the internal exporter in auto & hybrid mode, so both use the same server
+	p.endpoints = newEndpointSelector(arangodbEndpoint, func(ctx context.Context, endpoint string) error {
+		return p.available(ctx, newHttpRequestFactory(func() string { return endpoint }, auth))
+	}, timeout, "passthru", nil)
+	p.endpoints.subscribe(func() {
+		// Another server is used, which has its own identity & version
+		p.identity.reset()
+		p.api.reset()
+		p.transport.breaker.reset()
+		atomic.StoreUint32(p.splitFamilies, 0)
+	})
+	p.factory = newHttpRequestFactory(p.endpoints.currentEndpoint, auth)
+	p.api = &metricsAPI{getJSON: p.getJSON, api: cfg.MetricsAPI}
+	if cfg.IdentityLabels {
+		p.identity = &identity{getJSON: p.getJSON}
+	}
+	for _, reason := range scrapeErrorReasons {
+		p.scrapeErrors.WithLabelValues(reason)
+	}
+	p.metrics.MustRegister(p.upstreamStatus, p.parseError, p.filtered,
+		p.scrapeDuration, p.scrapeErrors, p.upstreamBytes, p.proxyUp, p.transport, p.endpoints, version.NewCollector("arangodb_exporter"))
+	// The scrape metrics share their names with those of the internal exporter, so hybrid mode leaves them out
+	p.scrape.MustRegister(p.up, p.totalScrapes, p.failedScrapes)
+	if cfg.Poll.Interval > 0 {
+		p.poller = newPoller(gathererFunc(func() ([]*dto.MetricFamily, error) {
+			return p.gather(context.Background())
+		}), cfg.Poll)
+		p.metrics.MustRegister(p.poller.ageCollector())
+	} else if cfg.CacheMaxAge > 0 {
+		p.cache = newScrapeCache(gathererFunc(func() ([]*dto.MetricFamily, error) {
+			return p.gather(context.Background())
+		}), cfg.CacheMaxAge, "passthru")
+		p.metrics.MustRegister(p.cache.ageCollector())
+	}
+	return p
+}
+
+// newHttpClient creates a long-lived HTTP client, reusing its connections across scrapes.
+func newHttpClient(sslVerify bool, timeout time.Duration, cfg TransportConfig) *http.Client {
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   timeout,
+			KeepAlive: cfg.KeepAlive,
+		}).DialContext,
+		MaxIdleConns:        cfg.MaxIdleConns,
+		MaxIdleConnsPerHost: cfg.MaxIdleConns,
+		IdleConnTimeout:     cfg.IdleConnTimeout,
+		TLSHandshakeTimeout: timeout,
+		ForceAttemptHTTP2:   cfg.HTTP2,
+	}
 
-		if !sslVerify {
-			transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	if !sslVerify {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   timeout,
+	}
+}
+
+type httpRequestFactory func(path string) (*http.Request, error)
+
+// newHttpRequestFactory returns a factory of requests for the given path, sent to the endpoint returned by the given function.
+// The JWT is requested from the given authentication for every request.
+func newHttpRequestFactory(endpoint func() string, auth Authentication) httpRequestFactory {
+	return func(path string) (*http.Request, error) {
+		req, err := http.NewRequest("GET", fmt.Sprintf("%s/%s", endpoint(), path), nil)
+		if err != nil {
+			return nil, maskAny(err)
 		}
 
 		jwt, err := auth()
 		if err != nil {
-			return nil, nil, err
+			return nil, err
 		}
 
 		if jwt != "" {
 			hdr, err := CreateArangodJwtAuthorizationHeader(jwt)
 			if err != nil {
-				return nil, nil, maskAny(err)
+				return nil, maskAny(err)
 			}
 			req.Header.Add("Authorization", hdr)
 		}
 
 		req.Header.Add("x-arango-allow-dirty-read", "true") // Allow read from follower in AF mode
 
-		client := &http.Client{
-			Transport: transport,
-			Timeout:   timeout,
-		}
-
-		return client, req, nil
+		return req, nil
 	}
 }
 
 type passthru struct {
-	factory httpClientFactory
+	client    *http.Client
+	transport *retryTransport
+	endpoints *endpointSelector
+	factory   httpRequestFactory
+
+	metrics        *prometheus.Registry
+	scrape         *prometheus.Registry
+	up             prometheus.Gauge
+	totalScrapes   prometheus.Counter
+	failedScrapes  prometheus.Counter
+	scrapeDuration prometheus.Histogram
+	scrapeErrors   *prometheus.CounterVec
+	upstreamBytes  prometheus.Counter
+	errorsAsDown   bool
+	api            *metricsAPI
+	proxyDBServers bool
+	proxyUp        *prometheus.GaugeVec
+	cache          *scrapeCache
+	poller         *poller
+	upstreamStatus prometheus.Gauge
+	parseError     prometheus.Gauge
+	maxBodySize    int64
+	extraLabels    map[string]string
+	identity       *identity
+	include        []*regexp.Regexp
+	exclude        []*regexp.Regexp
+	filtered       prometheus.Counter
+	splitFamilies  *uint32 // Set to 1 once the server returned a metric family in separate places
+}
+
+// labels returns the labels to add to all metrics of the server.
+func (p passthru) labels(ctx context.Context) map[string]string {
+	if p.identity == nil {
+		return p.extraLabels
+	}
+	result := make(map[string]string, len(p.extraLabels)+2)
+	for k, v := range p.extraLabels {
+		result[k] = v
+	}
+	for k, v := range p.identity.get(ctx) {
+		result[k] = v
+	}
+	return result
+}
+
+// upstreamError is returned when ArangoDB does not respond with metrics.
+type upstreamError struct {
+	status int // Status code of the response, 0 when the response itself is not acceptable
+	reason string
 }
 
-func (p passthru) get() (*http.Response, error) {
-	c, req, err := p.factory()
+func (e upstreamError) Error() string {
+	return e.reason
+}
+
+// scrapeStatus returns the status code to return to the scraper for the given error.
+// Error statuses of ArangoDB are propagated, all other errors result in a bad gateway.
+func scrapeStatus(err error) int {
+	if e, ok := errors.Cause(err).(upstreamError); ok && e.status >= 400 {
+		return e.status
+	}
+	return http.StatusBadGateway
+}
+
+// isMetricsContentType returns true if the given content type can contain Prometheus metrics.
+func isMetricsContentType(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
 	if err != nil {
-		return nil, err
+		return false
+	}
+	switch mediaType {
+	case "text/plain", "application/openmetrics-text":
+		return true
+	default:
+		return false
 	}
-	return c.Do(req)
 }
 
-func (p passthru) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
-	data, err := p.get()
+// available checks the availability of the server the requests of the given factory are sent to.
+// The request is sent without retries and without allowing dirty reads, so followers in Active Failover are not available.
+func (p passthru) available(ctx context.Context, factory httpRequestFactory) error {
+	req, err := factory("_admin/server/availability")
+	if err != nil {
+		return maskAny(err)
+	}
+	req.Header.Del("x-arango-allow-dirty-read")
+	data, err := p.transport.next.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		return maskAny(err)
+	}
+	defer closeBody(data)
+
+	if data.StatusCode != http.StatusOK {
+		return maskAny(upstreamError{
+			status: data.StatusCode,
+			reason: fmt.Sprintf("ArangoDB responded to _admin/server/availability with status %d %s", data.StatusCode, http.StatusText(data.StatusCode)),
+		})
+	}
+	return nil
+}
+
+// getJSON requests the given path and parses the JSON response into the given result.
+// Responses larger than maxJSONSize are rejected.
+func (p passthru) getJSON(ctx context.Context, path string, result interface{}) error {
+	req, err := p.factory(path)
+	if err != nil {
+		return maskAny(err)
+	}
+	data, err := p.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return maskAny(err)
+	}
+	defer closeBody(data)
+
+	if data.StatusCode != http.StatusOK {
+		return maskAny(upstreamError{
+			status: data.StatusCode,
+			reason: fmt.Sprintf("ArangoDB responded to %s with status %d %s", path, data.StatusCode, http.StatusText(data.StatusCode)),
+		})
+	}
+	if err := json.NewDecoder(&limitedReader{r: data.Body, remaining: maxJSONSize}).Decode(result); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
+
+// fetch requests the metrics at the given path, checking that the response contains metrics.
+// Requests for metrics of other servers, proxied by the server, do not affect the state kept for the server itself,
+// nor its scrape errors. Their failures are reported in the proxy up metric instead.
+// The caller must close the body of the returned response.
+func (p passthru) fetch(ctx context.Context, path string, proxied bool) (*http.Response, error) {
+	req, err := p.factory(path)
+	if err != nil {
+		p.countScrapeError(scrapeErrorRequest, proxied)
+		return nil, maskAny(err)
+	}
+	// Setting the header disables transparent decompression, which is done in stream
+	req.Header.Set("Accept-Encoding", "gzip")
 
+	data, err := p.client.Do(req.WithContext(ctx))
 	if err != nil {
-		// Ignore error
-		resp.WriteHeader(http.StatusInternalServerError)
-		resp.Write([]byte(err.Error()))
-		return
+		if !proxied {
+			p.upstreamStatus.Set(0)
+			p.identity.reset()
+			p.api.reset()
+		}
+		p.countScrapeError(scrapeErrorConnection, proxied)
+		return nil, maskAny(err)
 	}
 
 	if data.Body == nil {
-		// Ignore error
-		resp.WriteHeader(http.StatusInternalServerError)
-		resp.Write([]byte("Body is empty"))
-		return
+		p.countScrapeError(scrapeErrorRead, proxied)
+		return nil, maskAny(fmt.Errorf("Body is empty"))
 	}
 
-	defer data.Body.Close()
+	if !proxied {
+		p.upstreamStatus.Set(float64(data.StatusCode))
+	}
+	if data.StatusCode != http.StatusOK {
+		closeBody(data)
+		if !proxied {
+			p.identity.reset()
+			p.api.reset()
+		}
+		p.countScrapeError(scrapeErrorStatus, proxied)
+		return nil, maskAny(upstreamError{
+			status: data.StatusCode,
+			reason: fmt.Sprintf("ArangoDB responded with status %d %s", data.StatusCode, http.StatusText(data.StatusCode)),
+		})
+	}
+	if contentType := data.Header.Get("Content-Type"); !isMetricsContentType(contentType) {
+		closeBody(data)
+		p.countScrapeError(scrapeErrorContentType, proxied)
+		return nil, maskAny(upstreamError{
+			reason: fmt.Sprintf("ArangoDB responded with unexpected content type '%s'", contentType),
+		})
+	}
+	if p.maxBodySize > 0 && data.ContentLength > p.maxBodySize {
+		closeBody(data)
+		p.countScrapeError(scrapeErrorOversized, proxied)
+		return nil, maskAny(upstreamError{
+			reason: fmt.Sprintf("ArangoDB responded with %d bytes, exceeding the maximum body size of %d bytes", data.ContentLength, p.maxBodySize),
+		})
+	}
+	return data, nil
+}
 
-	response, err := ioutil.ReadAll(data.Body)
+// countScrapeError counts a failed scrape of the server for the given reason.
+// Failures of proxied requests are not counted, they only affect the proxy up metric.
+func (p passthru) countScrapeError(reason string, proxied bool) {
+	if !proxied {
+		p.scrapeErrors.WithLabelValues(reason).Inc()
+	}
+}
+
+// closeBody drains (a limited part of) the body of the given response and closes it,
+// so the connection can be reused.
+func closeBody(data *http.Response) {
+	io.Copy(ioutil.Discard, io.LimitReader(data.Body, maxDrainSize))
+	data.Body.Close()
+}
+
+// stream fetches the metrics of the server and calls fn for every metric family,
+// in the order in which the server returns them.
+// Metric families that cannot be parsed are skipped and reported in the exporter metrics.
+// When no metric family at all can be parsed, the scrape fails.
+func (p passthru) stream(ctx context.Context, fn func(*dto.MetricFamily) error) (err error) {
+	start := time.Now()
+	p.totalScrapes.Inc()
+	defer func() {
+		p.scrapeDuration.Observe(time.Since(start).Seconds())
+		if err != nil {
+			p.up.Set(0)
+			p.failedScrapes.Inc()
+			p.endpoints.failover()
+		} else {
+			p.up.Set(1)
+		}
+	}()
+
+	// The endpoint is selected at the first scrape
+	p.endpoints.get(ctx)
+	if labels := p.labels(ctx); len(labels) > 0 {
+		next := fn
+		fn = func(mf *dto.MetricFamily) error {
+			addLabels(mf, labels)
+			return next(mf)
+		}
+	}
+
+	if len(p.include) > 0 || len(p.exclude) > 0 {
+		next := fn
+		fn = func(mf *dto.MetricFamily) error {
+			if !includeFamily(mf.GetName(), p.include, p.exclude) {
+				p.filtered.Add(float64(seriesCount(mf)))
+				return nil
+			}
+			return next(mf)
+		}
+	}
+
+	parsed := 0
+	next := fn
+	fn = func(mf *dto.MetricFamily) error {
+		parsed++
+		return next(mf)
+	}
+
+	if p.proxyDBServers {
+		err = p.streamCluster(ctx, fn)
+	} else {
+		err = p.streamServer(ctx, p.api.get(ctx), false, fn)
+	}
+	if e, ok := errors.Cause(err).(parseError); ok {
+		// Do not forward output that scrapers are unable to parse
+		log.Errorf("Failed to parse ArangoDB metrics: %v", e)
+		p.parseError.Set(1)
+		p.scrapeErrors.WithLabelValues(scrapeErrorParse).Inc()
+		if parsed == 0 {
+			// Nothing could be parsed, e.g. because ArangoDB responded with something else than metrics
+			return maskAny(err)
+		}
+		return nil
+	} else if err != nil {
+		return maskAny(err)
+	}
+	p.parseError.Set(0)
+	return nil
+}
+
+// streamServer fetches the metrics at the given path and calls fn for every metric family.
+// When (part of) the metrics cannot be parsed, a parseError is returned after all other families.
+func (p passthru) streamServer(ctx context.Context, path string, proxied bool, fn func(*dto.MetricFamily) error) error {
+	data, err := p.fetch(ctx, path, proxied)
+	if err != nil {
+		return maskAny(err)
+	}
+	defer closeBody(data)
+
+	counter := &countingReader{r: data.Body}
+	defer func() {
+		p.upstreamBytes.Add(float64(counter.n))
+	}()
+
+	var body io.Reader = counter
+	if data.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			p.countScrapeError(scrapeErrorRead, proxied)
+			return maskAny(err)
+		}
+		defer gz.Close()
+		body = gz
+	}
+	// The limit applies to the uncompressed metrics, since that is what is kept in memory
+	if p.maxBodySize > 0 {
+		body = &limitedReader{r: body, remaining: p.maxBodySize}
+	}
+
+	err = readFamilies(body, fn)
+	if _, ok := errors.Cause(err).(parseError); ok {
+		return maskAny(err)
+	} else if err != nil {
+		if errors.Cause(err) == errResponseTooLarge {
+			p.countScrapeError(scrapeErrorOversized, proxied)
+		} else {
+			p.countScrapeError(scrapeErrorRead, proxied)
+		}
+		return maskAny(err)
+	}
+	return nil
+}
+
+// Gather returns the metric families of the server, sorted by name, from the poller or cache when enabled.
+// It implements prometheus.Gatherer.
+func (p passthru) Gather() ([]*dto.MetricFamily, error) {
+	return p.gatherContext(context.Background())
+}
+
+// gatherContext is Gather, giving up on the server when the given context is done.
+// The poller & cache fetch the metrics independently of the given context.
+func (p passthru) gatherContext(ctx context.Context) ([]*dto.MetricFamily, error) {
+	if g := p.buffered(); g != nil {
+		return g.Gather()
+	}
+	return p.gather(ctx)
+}
+
+// buffered returns the poller or cache serving the metric families of the server,
+// nil when they are streamed from the server for every scrape.
+func (p passthru) buffered() prometheus.Gatherer {
+	if p.poller != nil {
+		return p.poller
+	}
+	if p.cache != nil {
+		return p.cache
+	}
+	return nil
+}
+
+// errSplitFamily is returned when the server returned the samples of a metric family in separate places,
+// after the first of them has been streamed.
+var errSplitFamily = fmt.Errorf("ArangoDB returned a metric family in separate places, its samples are merged from the next scrape on")
+
+// families calls fn for every metric family of the server. Without poller or cache, the metric families
+// are streamed from the server. Otherwise, or when the server returned a metric family in separate places before,
+// all metric families are gathered first, merging families with the same name.
+func (p passthru) families(ctx context.Context, fn func(*dto.MetricFamily) error) error {
+	g := p.buffered()
+	if g == nil && atomic.LoadUint32(p.splitFamilies) == 0 {
+		// A family that was already streamed cannot be merged, it would be exposed twice
+		streamed := make(map[string]struct{})
+		split := false
+		err := p.stream(ctx, func(mf *dto.MetricFamily) error {
+			if _, found := streamed[mf.GetName()]; found {
+				split = true
+				return nil
+			}
+			streamed[mf.GetName()] = struct{}{}
+			return fn(mf)
+		})
+		if err == nil && split {
+			atomic.StoreUint32(p.splitFamilies, 1)
+			return maskAny(errSplitFamily)
+		}
+		return err
+	}
+	if g == nil {
+		g = gathererFunc(func() ([]*dto.MetricFamily, error) {
+			return p.gather(ctx)
+		})
+	}
+	families, err := g.Gather()
 	if err != nil {
-		// Ignore error
-		resp.WriteHeader(http.StatusInternalServerError)
-		resp.Write([]byte(err.Error()))
-		return
+		// A stale snapshot is reported as down, even when its poll succeeded
+		p.up.Set(0)
+		return maskAny(err)
+	}
+	for _, mf := range families {
+		if err := fn(mf); err != nil {
+			return maskAny(err)
+		}
+	}
+	return nil
+}
+
+// gather fetches the metrics of the server and parses them into metric families, sorted by name.
+// Metric families returned in separate places are merged.
+func (p passthru) gather(ctx context.Context) ([]*dto.MetricFamily, error) {
+	parsed := make(map[string]*dto.MetricFamily)
+	if err := p.stream(ctx, func(mf *dto.MetricFamily) error {
+		mergeFamily(parsed, mf)
+		return nil
+	}); err != nil {
+		return nil, maskAny(err)
+	}
+	return sortFamilies(parsed), nil
+}
+
+// CloseIdleConnections closes the idle connections to ArangoDB.
+func (p passthru) CloseIdleConnections() {
+	p.transport.CloseIdleConnections()
+}
+
+// stop ends polling ArangoDB in the background, if enabled.
+// It implements stopper.
+func (p passthru) stop() {
+	if p.poller != nil {
+		p.poller.stop()
 	}
+}
 
-	responseStr := string(response)
+// self returns the metrics of the exporter itself.
+func (p passthru) self() prometheus.Gatherer {
+	return prometheus.Gatherers{p.metrics, p.scrape}
+}
 
-	// Fix Header response
-	responseStr = strings.ReplaceAll(responseStr, "guage", "gauge")
+func (p passthru) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	ctx, cancel := scrapeContext(req)
+	defer cancel()
+
+	// The encoder is created with the first metric family, so errors can still be reported with a proper status
+	var enc metricsEncoder
+	err := p.families(ctx, func(mf *dto.MetricFamily) error {
+		if enc == nil {
+			enc = newResponseEncoder(resp, req)
+		}
+		return enc.Encode(mf)
+	})
+	if err != nil {
+		log.Errorf("Failed to fetch ArangoDB metrics: %v", err)
+		if enc != nil {
+			// The status has already been sent, abort the response so scrapers do not accept partial metrics
+			panic(http.ErrAbortHandler)
+		}
+		if !p.errorsAsDown {
+			p.writeError(resp, scrapeStatus(err), err)
+			return
+		}
+	}
+	if enc == nil {
+		enc = newResponseEncoder(resp, req)
+	}
 
-	_, err = resp.Write([]byte(responseStr))
+	self, err := p.self().Gather()
 	if err != nil {
-		// Ignore error
-		resp.WriteHeader(http.StatusInternalServerError)
-		resp.Write([]byte("Unable to write body"))
-		return
+		log.Errorf("Failed to gather exporter metrics: %v", err)
 	}
+	for _, mf := range self {
+		if err := enc.Encode(mf); err != nil {
+			log.Errorf("Failed to encode metrics: %v", err)
+			return
+		}
+	}
+	if err := enc.Close(); err != nil {
+		log.Errorf("Failed to encode metrics: %v", err)
+	}
+}
+
+// writeError responds with the given status. The body contains the error as comment, for humans.
+// Scrapers discard the body of error responses, so the exporter metrics are left out; they are
+// served for failed scrapes when errors are exposed as arangodb_up 0.
+func (p passthru) writeError(resp http.ResponseWriter, status int, cause error) {
+	resp.Header().Set("Content-Type", string(expfmt.FmtText))
+	resp.WriteHeader(status)
+	// Ignore error
+	fmt.Fprintf(resp, "# %s\n", strings.Replace(cause.Error(), "\n", " ", -1))
 }
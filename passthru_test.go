@@ -0,0 +1,238 @@
+//
+// DISCLAIMER
+//
+// Copyright 2018 ArangoDB GmbH, Cologne, Germany
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Copyright holder is ArangoDB GmbH, Cologne, Germany
+//
+
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestPassthruSelfMetrics tests the exporter metrics served in passthru mode.
+func TestPassthruSelfMetrics(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_admin/metrics" {
+			http.NotFound(w, r)
+			return
+		}
+		switch r.Header.Get("X-Fail") {
+		case "status":
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		case "garbage":
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte("<html>this is not metrics</html>"))
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("# TYPE arangodb_client_connections gauge\narangodb_client_connections 3\n"))
+	}))
+	defer upstream.Close()
+
+	auth := func() (string, error) { return "", nil }
+	tests := []struct {
+		Fail         string
+		ErrorsAsDown bool
+		MaxBodySize  int64
+		Status       int
+		Contains     []string
+	}{
+		{"", false, 0, http.StatusOK, []string{"arangodb_client_connections 3", "arangodb_up 1", "arangodb_exporter_passthru_upstream_bytes 71"}},
+		{"status", false, 0, http.StatusServiceUnavailable, []string{"# ArangoDB responded with status 503 Service Unavailable"}},
+		{"status", true, 0, http.StatusOK, []string{"arangodb_up 0", "arangodb_exporter_failed_scrapes 1", "arangodb_exporter_passthru_upstream_status 503"}},
+		{"", true, 16, http.StatusOK, []string{"arangodb_up 0", `arangodb_exporter_passthru_scrape_errors{reason="oversized"} 1`}},
+		{"garbage", false, 0, http.StatusBadGateway, []string{"# text format parsing error"}},
+		{"garbage", true, 0, http.StatusOK, []string{"arangodb_up 0", "arangodb_exporter_failed_scrapes 1", "arangodb_exporter_passthru_parse_error 1",
+			`arangodb_exporter_passthru_scrape_errors{reason="parse"} 1`}},
+	}
+
+	for i, test := range tests {
+		p := newPassthru(upstream.URL, auth, false, time.Second, PassthruConfig{ErrorsAsDown: test.ErrorsAsDown, MaxBodySize: test.MaxBodySize})
+		if test.Fail != "" {
+			factory := p.factory
+			p.factory = func(path string) (*http.Request, error) {
+				req, err := factory(path)
+				if err == nil {
+					req.Header.Set("X-Fail", test.Fail)
+				}
+				return req, err
+			}
+		}
+
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		if rec.Code != test.Status {
+			t.Errorf("ServeHTTP for test %d failed: got status %d, expected %d", i, rec.Code, test.Status)
+		}
+		body, _ := ioutil.ReadAll(rec.Body)
+		for _, expected := range test.Contains {
+			if !strings.Contains(string(body), expected) {
+				t.Errorf("ServeHTTP for test %d failed: expected %q in\n%s", i, expected, body)
+			}
+		}
+	}
+}
+
+// TestScrapeStatus tests the status returned to the scraper for various errors.
+func TestScrapeStatus(t *testing.T) {
+	tests := []struct {
+		Err    error
+		Status int
+	}{
+		{upstreamError{status: http.StatusUnauthorized, reason: "unauthorized"}, http.StatusUnauthorized},
+		{maskAny(upstreamError{status: http.StatusServiceUnavailable, reason: "starting"}), http.StatusServiceUnavailable},
+		{upstreamError{reason: "unexpected content type"}, http.StatusBadGateway},
+		{upstreamError{status: http.StatusMovedPermanently, reason: "moved"}, http.StatusBadGateway},
+		{errors.New("connection refused"), http.StatusBadGateway},
+	}
+
+	for i, test := range tests {
+		if result := scrapeStatus(test.Err); result != test.Status {
+			t.Errorf("scrapeStatus for test %d failed: got %d, expected %d", i, result, test.Status)
+		}
+	}
+}
+
+// TestIsMetricsContentType tests the result of isMetricsContentType for various content types.
+func TestIsMetricsContentType(t *testing.T) {
+	tests := []struct {
+		ContentType string
+		Expected    bool
+	}{
+		{"", true},
+		{"text/plain", true},
+		{"text/plain; version=0.0.4; charset=utf-8", true},
+		{"application/openmetrics-text; version=1.0.0", true},
+		{"text/html; charset=utf-8", false},
+		{"application/json", false},
+		{"text/plain; =", false},
+	}
+
+	for i, test := range tests {
+		if result := isMetricsContentType(test.ContentType); result != test.Expected {
+			t.Errorf("isMetricsContentType for test %d (%s) failed: got %v, expected %v", i, test.ContentType, result, test.Expected)
+		}
+	}
+}
+
+// TestPassthruSplitFamilies tests that metric families returned in separate places are exposed once, with all samples.
+func TestPassthruSplitFamilies(t *testing.T) {
+	split := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("# TYPE a gauge\na 1\n# TYPE b gauge\nb 2\n# TYPE a gauge\na{x=\"y\"} 3\n"))
+	})
+	upstream := httptest.NewServer(split)
+	defer upstream.Close()
+	other := httptest.NewServer(split)
+	defer other.Close()
+
+	p := newPassthru(upstream.URL, func() (string, error) { return "", nil }, false, time.Second, PassthruConfig{MetricsAPI: MetricsAPIV1})
+	serve := func() (rec *httptest.ResponseRecorder, aborted bool) {
+		defer func() {
+			if r := recover(); r != nil {
+				if r != http.ErrAbortHandler {
+					panic(r)
+				}
+				aborted = true
+			}
+		}()
+		rec = httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		return rec, false
+	}
+
+	// The first family has been streamed when the split is found, so the response is aborted
+	if _, aborted := serve(); !aborted {
+		t.Error("First scrape was not aborted")
+	}
+	rec, aborted := serve()
+	if aborted {
+		t.Fatal("Second scrape was aborted")
+	}
+	body := rec.Body.String()
+	if n := strings.Count(body, "# TYPE a gauge"); n != 1 {
+		t.Errorf("Second scrape exposes family a %d times, expected once in\n%s", n, body)
+	}
+	for _, expected := range []string{"a 1", `a{x="y"} 3`, "b 2"} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("Second scrape failed: expected %q in\n%s", expected, body)
+		}
+	}
+
+	// Another server may not split its families, so they are streamed again
+	p.endpoints.use(other.URL)
+	if _, aborted := serve(); !aborted {
+		t.Error("First scrape of another server was not aborted")
+	}
+	if _, aborted := serve(); aborted {
+		t.Error("Second scrape of another server was aborted")
+	}
+}
+
+// TestPassthruConnectionReuse tests that scrapes reuse the connection to ArangoDB, until the idle connections are closed.
+func TestPassthruConnectionReuse(t *testing.T) {
+	for _, tls := range []bool{false, true} {
+		var connections int32
+		upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte("# TYPE arangodb_client_connections gauge\narangodb_client_connections 3\n"))
+		}))
+		upstream.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+			if state == http.StateNew {
+				atomic.AddInt32(&connections, 1)
+			}
+		}
+		if tls {
+			upstream.StartTLS()
+		} else {
+			upstream.Start()
+		}
+
+		cfg := PassthruConfig{MetricsAPI: MetricsAPIV1, Transport: TransportConfig{MaxIdleConns: 2, IdleConnTimeout: time.Minute, HTTP2: true}}
+		p := newPassthru(upstream.URL, func() (string, error) { return "", nil }, false, time.Second, cfg)
+		scrape := func() {
+			rec := httptest.NewRecorder()
+			p.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+			if rec.Code != http.StatusOK {
+				t.Errorf("ServeHTTP (TLS %v) failed: got status %d, expected %d", tls, rec.Code, http.StatusOK)
+			}
+		}
+
+		for i := 0; i < 5; i++ {
+			scrape()
+		}
+		if n := atomic.LoadInt32(&connections); n != 1 {
+			t.Errorf("Scrapes (TLS %v) opened %d connections, expected 1", tls, n)
+		}
+		p.CloseIdleConnections()
+		scrape()
+		if n := atomic.LoadInt32(&connections); n != 2 {
+			t.Errorf("Scrape (TLS %v) after closing the idle connections opened %d connections in total, expected 2", tls, n)
+		}
+		upstream.Close()
+	}
+}
@@ -0,0 +1,184 @@
+//
+// DISCLAIMER
+//
+// Copyright 2018 ArangoDB GmbH, Cologne, Germany
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Copyright holder is ArangoDB GmbH, Cologne, Germany
+//
+
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/log"
+)
+
+const (
+	// pollJitter is the maximum fraction of the poll interval randomly added to it,
+	// so exporters started at the same time do not poll at the same time.
+	pollJitter = 0.1
+)
+
+var _ prometheus.Gatherer = &poller{}
+
+// stopper is implemented by handlers that do work in the background, which has to be stopped
+// when the exporter shuts down.
+type stopper interface {
+	stop()
+}
+
+// errNoSnapshot is returned when metrics are requested before the first poll completed.
+var errNoSnapshot = fmt.Errorf("No metrics snapshot available yet")
+
+// PollConfig settings for polling ArangoDB in the background
+type PollConfig struct {
+	Interval   time.Duration // Interval between polls, 0 disables polling
+	StaleAfter time.Duration // Age after which a snapshot is no longer served, 0 for three times the interval
+}
+
+// poller gathers the metric families of a gatherer in the background, keeping the result of the last poll.
+// Scrapes are served from this snapshot, so they do not depend on the response time of ArangoDB.
+type poller struct {
+	gatherer   prometheus.Gatherer
+	interval   time.Duration
+	staleAfter time.Duration
+	age        prometheus.GaugeFunc
+	done       chan struct{}
+	stopOnce   sync.Once
+
+	mutex    sync.Mutex
+	families []*dto.MetricFamily
+	err      error
+	polled   time.Time
+}
+
+// newPoller returns a poller of the given gatherer, which starts polling immediately until stopped.
+func newPoller(gatherer prometheus.Gatherer, cfg PollConfig) *poller {
+	p := &poller{
+		gatherer:   gatherer,
+		interval:   cfg.Interval,
+		staleAfter: cfg.StaleAfter,
+		done:       make(chan struct{}),
+	}
+	if p.staleAfter <= 0 {
+		p.staleAfter = 3 * p.interval
+	}
+	p.age = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "exporter_poll_snapshot_age_seconds",
+		Help:      "Age of the polled ArangoDB metrics snapshot in seconds, 0 if no poll has completed yet.",
+	}, p.ageSeconds)
+	go p.run()
+	return p
+}
+
+// ageCollector returns the collector exposing the age of the snapshot.
+func (p *poller) ageCollector() prometheus.Collector {
+	return p.age
+}
+
+// run polls the gatherer until the poller is stopped.
+func (p *poller) run() {
+	for {
+		p.poll()
+		jitter := time.Duration(rand.Float64() * pollJitter * float64(p.interval))
+		timer := time.NewTimer(p.interval + jitter)
+		select {
+		case <-p.done:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// stop ends polling in the background. A poll in progress is completed first.
+// The last snapshot is still served until it becomes stale.
+func (p *poller) stop() {
+	p.stopOnce.Do(func() {
+		close(p.done)
+	})
+}
+
+// poll gathers the metric families and replaces the snapshot by the result.
+func (p *poller) poll() {
+	families, err := p.gatherer.Gather()
+	if err != nil {
+		log.Warnf("Failed to poll ArangoDB metrics: %v", err)
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.families = families
+	p.err = err
+	p.polled = time.Now()
+}
+
+// Gather returns the result of the last poll. When the snapshot is older than the staleness cutoff,
+// its metrics are no longer returned. Instead arangodb_up is reported as 0, together with an error.
+// It implements prometheus.Gatherer.
+func (p *poller) Gather() ([]*dto.MetricFamily, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.polled.IsZero() {
+		return nil, maskAny(errNoSnapshot)
+	}
+	if age := time.Since(p.polled); age > p.staleAfter {
+		return downFamilies(p.families), maskAny(fmt.Errorf("Metrics snapshot is %s old, exceeding the staleness cutoff of %s", age, p.staleAfter))
+	}
+	return p.families, p.err
+}
+
+// ageSeconds returns the age of the snapshot in seconds, 0 if no poll has completed yet.
+func (p *poller) ageSeconds() float64 {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.polled.IsZero() {
+		return 0
+	}
+	return time.Since(p.polled).Seconds()
+}
+
+// downFamilies returns a copy of the arangodb_up family in the given metric families, with all values set to 0.
+func downFamilies(families []*dto.MetricFamily) []*dto.MetricFamily {
+	for _, mf := range families {
+		if mf.GetName() != namespace+"_up" {
+			continue
+		}
+		down := &dto.MetricFamily{
+			Name: mf.Name,
+			Help: mf.Help,
+			Type: mf.Type,
+		}
+		for _, m := range mf.GetMetric() {
+			down.Metric = append(down.Metric, &dto.Metric{
+				Label: m.Label,
+				Gauge: &dto.Gauge{Value: proto.Float64(0)},
+			})
+		}
+		return []*dto.MetricFamily{down}
+	}
+	return nil
+}
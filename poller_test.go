@@ -0,0 +1,90 @@
+//
+// DISCLAIMER
+//
+// Copyright 2018 ArangoDB GmbH, Cologne, Germany
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Copyright holder is ArangoDB GmbH, Cologne, Germany
+//
+
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// TestPoller tests the snapshot served by the poller, depending on its age.
+func TestPoller(t *testing.T) {
+	up := family("arangodb_up", dto.MetricType_GAUGE)
+	up.Metric = []*dto.Metric{{Gauge: &dto.Gauge{Value: proto.Float64(1)}}}
+	connections := family("arangodb_client_connections", dto.MetricType_GAUGE)
+
+	p := &poller{
+		gatherer: gathererFunc(func() ([]*dto.MetricFamily, error) {
+			return []*dto.MetricFamily{up, connections}, nil
+		}),
+		staleAfter: time.Minute,
+	}
+
+	if _, err := p.Gather(); err == nil {
+		t.Errorf("Gather before the first poll failed: expected an error")
+	}
+
+	p.poll()
+	if families, err := p.Gather(); err != nil || len(families) != 2 {
+		t.Errorf("Gather of a fresh snapshot failed: got %d families, error %v", len(families), err)
+	}
+
+	p.polled = time.Now().Add(-2 * time.Minute)
+	families, err := p.Gather()
+	if err == nil {
+		t.Errorf("Gather of a stale snapshot failed: expected an error")
+	}
+	if len(families) != 1 || families[0].GetName() != "arangodb_up" || families[0].GetMetric()[0].GetGauge().GetValue() != 0 {
+		t.Errorf("Gather of a stale snapshot failed: expected arangodb_up 0, got %v", families)
+	}
+	if up.GetMetric()[0].GetGauge().GetValue() != 1 {
+		t.Errorf("Gather of a stale snapshot failed: the snapshot was modified")
+	}
+}
+
+// TestPollerStop tests that a stopped poller no longer polls, but still serves its last snapshot.
+func TestPollerStop(t *testing.T) {
+	var polls int32
+	p := newPoller(gathererFunc(func() ([]*dto.MetricFamily, error) {
+		atomic.AddInt32(&polls, 1)
+		return []*dto.MetricFamily{family("arangodb_up", dto.MetricType_GAUGE)}, nil
+	}), PollConfig{Interval: 5 * time.Millisecond, StaleAfter: time.Minute})
+
+	time.Sleep(20 * time.Millisecond)
+	p.stop()
+	p.stop() // Stopping twice is harmless
+	time.Sleep(10 * time.Millisecond)
+	stopped := atomic.LoadInt32(&polls)
+	if stopped == 0 {
+		t.Errorf("Poller did not poll before it was stopped")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if n := atomic.LoadInt32(&polls); n != stopped {
+		t.Errorf("Poller polled %d times after it was stopped", n-stopped)
+	}
+	if families, err := p.Gather(); err != nil || len(families) != 1 {
+		t.Errorf("Gather after stop failed: got %d families, error %v", len(families), err)
+	}
+}
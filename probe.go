@@ -0,0 +1,237 @@
+//
+// DISCLAIMER
+//
+// Copyright 2018 ArangoDB GmbH, Cologne, Germany
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Copyright holder is ArangoDB GmbH, Cologne, Germany
+//
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// probeIdleTimeout is the time after which the handler of a target that has not been probed is dropped.
+	probeIdleTimeout = time.Minute * 10
+)
+
+var _ http.Handler = &probe{}
+
+// NewProbe returns a handler that serves the metrics of the ArangoDB server given
+// in the `target` query parameter, using the mode given in the `mode` query parameter.
+// Only targets matching one of the given allowed patterns are scraped, since the
+// authentication of the exporter is sent to them.
+func NewProbe(defaultMode ExporterMode, auth Authentication, sslVerify bool, timeout time.Duration, passthruCfg PassthruConfig, allowed []*regexp.Regexp) http.Handler {
+	// Probed servers are scraped on demand, polling them would never stop
+	passthruCfg.Poll = PollConfig{}
+
+	return &probe{
+		defaultMode: defaultMode,
+		auth:        auth,
+		sslVerify:   sslVerify,
+		timeout:     timeout,
+		passthruCfg: passthruCfg,
+		allowed:     allowed,
+		targets:     make(map[probeKey]*probeTarget),
+	}
+}
+
+// compileProbeTargets compiles the given regular expressions, anchored so they must match entire target endpoints.
+func compileProbeTargets(exprs []string) ([]*regexp.Regexp, error) {
+	result := make([]*regexp.Regexp, 0, len(exprs))
+	for _, expr := range exprs {
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("Invalid probe target pattern '%s': %v", expr, err)
+		}
+		result = append(result, re)
+	}
+	return result, nil
+}
+
+type probeKey struct {
+	endpoint string
+	mode     ExporterMode
+}
+
+type probeTarget struct {
+	handler  http.Handler
+	close    func() // Closes the idle connections of the handler
+	lastUsed time.Time
+}
+
+// idleCloser is implemented by handlers that keep connections to ArangoDB open across scrapes.
+type idleCloser interface {
+	CloseIdleConnections()
+}
+
+type probe struct {
+	defaultMode ExporterMode
+	auth        Authentication
+	sslVerify   bool
+	timeout     time.Duration
+	passthruCfg PassthruConfig
+	allowed     []*regexp.Regexp
+	group       singleflight.Group
+
+	mutex   sync.Mutex
+	targets map[probeKey]*probeTarget
+}
+
+func (p *probe) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+
+	endpoint, err := parseProbeTarget(query.Get("target"))
+	if err != nil {
+		http.Error(resp, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !matchesAny(endpoint, p.allowed) {
+		http.Error(resp, fmt.Sprintf("Target '%s' is not allowed", endpoint), http.StatusForbidden)
+		return
+	}
+
+	mode := p.defaultMode
+	if m := query.Get("mode"); m != "" {
+		mode = ExporterMode(m)
+	}
+
+	handler, err := p.getHandler(probeKey{endpoint: endpoint, mode: mode})
+	if err != nil {
+		http.Error(resp, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	handler.ServeHTTP(resp, req)
+}
+
+// getHandler returns the handler for the given target, creating it if needed.
+// Handlers are reused across requests, so connections to the target are reused as well.
+// Handlers are created outside the lock, since that involves requests to the target,
+// concurrent requests for the same target wait for the same handler.
+func (p *probe) getHandler(key probeKey) (http.Handler, error) {
+	if handler, found := p.cached(key); found {
+		return handler, nil
+	}
+
+	h, err, _ := p.group.Do(fmt.Sprintf("%s %s", key.mode, key.endpoint), func() (interface{}, error) {
+		// Another request may have created the handler while waiting
+		if handler, found := p.cached(key); found {
+			return handler, nil
+		}
+		handler, err := p.newHandler(key)
+		if err != nil {
+			return nil, err
+		}
+		t := &probeTarget{handler: handler, close: func() {}, lastUsed: time.Now()}
+		if c, ok := handler.(idleCloser); ok {
+			t.close = c.CloseIdleConnections
+		}
+		p.mutex.Lock()
+		p.targets[key] = t
+		p.mutex.Unlock()
+		return handler, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return h.(http.Handler), nil
+}
+
+// cached returns the handler for the given target, if there is one.
+// Targets that have not been probed for a while are dropped, closing their connections.
+func (p *probe) cached(key probeKey) (http.Handler, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	now := time.Now()
+	for k, t := range p.targets {
+		if now.Sub(t.lastUsed) > probeIdleTimeout {
+			delete(p.targets, k)
+			t.close()
+		}
+	}
+
+	t, found := p.targets[key]
+	if !found {
+		return nil, false
+	}
+	t.lastUsed = now
+	return t.handler, true
+}
+
+// newHandler creates a handler serving the metrics of the given target.
+func (p *probe) newHandler(key probeKey) (http.Handler, error) {
+	switch key.mode {
+	case ModePassthru:
+		return NewPassthru(key.endpoint, p.auth, p.sslVerify, p.timeout, p.passthruCfg)
+	case ModeAuto:
+		return NewAutoMode(key.endpoint, p.auth, p.sslVerify, p.timeout, p.passthruCfg)
+	case ModeHybrid:
+		return NewHybrid(key.endpoint, p.auth, p.sslVerify, p.timeout, p.passthruCfg)
+	case ModeInternal:
+		exporter, err := NewExporter(key.endpoint, p.auth, p.sslVerify, p.timeout)
+		if err != nil {
+			return nil, maskAny(err)
+		}
+		source := newScrapeSource(exporter, p.passthruCfg.CacheMaxAge)
+		return probeHandler{Handler: newScrapeHandler(source), closer: exporter}, nil
+	default:
+		return nil, fmt.Errorf("Unknown mode '%s'", key.mode)
+	}
+}
+
+// parseProbeTarget returns the endpoint for the given target.
+// A target without scheme is considered to be a plain HTTP endpoint.
+func parseProbeTarget(target string) (string, error) {
+	if target == "" {
+		return "", fmt.Errorf("Target parameter is missing")
+	}
+	if !strings.Contains(target, "://") {
+		target = "http://" + target
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", fmt.Errorf("Invalid target '%s': %v", target, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("Invalid target '%s': unsupported scheme '%s'", target, u.Scheme)
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("Invalid target '%s': host is missing", target)
+	}
+	return strings.TrimSuffix(u.Scheme+"://"+u.Host+u.Path, "/"), nil
+}
+
+// probeHandler is a handler that closes the idle connections of the given closer.
+type probeHandler struct {
+	http.Handler
+	closer idleCloser
+}
+
+// CloseIdleConnections implements idleCloser.
+func (h probeHandler) CloseIdleConnections() {
+	h.closer.CloseIdleConnections()
+}
@@ -0,0 +1,151 @@
+//
+// DISCLAIMER
+//
+// Copyright 2018 ArangoDB GmbH, Cologne, Germany
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Copyright holder is ArangoDB GmbH, Cologne, Germany
+//
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestParseProbeTarget tests the result of parseProbeTarget for various inputs.
+func TestParseProbeTarget(t *testing.T) {
+	tests := []struct {
+		Target   string
+		Endpoint string
+		Valid    bool
+	}{
+		{"", "", false},
+		{"localhost:8529", "http://localhost:8529", true},
+		{"http://localhost:8529/", "http://localhost:8529", true},
+		{"https://db.example.com:8529", "https://db.example.com:8529", true},
+		{"ftp://localhost:8529", "", false},
+		{"http://", "", false},
+	}
+
+	for i, test := range tests {
+		result, err := parseProbeTarget(test.Target)
+		if test.Valid && err != nil {
+			t.Errorf("parseProbeTarget for test %d failed: %v", i, err)
+		} else if !test.Valid && err == nil {
+			t.Errorf("parseProbeTarget for test %d succeeded, expected an error", i)
+		} else if result != test.Endpoint {
+			t.Errorf("parseProbeTarget for test %d failed: got '%s', expected '%s'", i, result, test.Endpoint)
+		}
+	}
+}
+
+// TestProbeAllowedTargets tests that only targets matching the allowed patterns are probed.
+func TestProbeAllowedTargets(t *testing.T) {
+	allowed, err := compileProbeTargets([]string{`https://db-[0-9]+\.example\.com:8529`})
+	if err != nil {
+		t.Fatalf("compileProbeTargets failed: %v", err)
+	}
+	p := NewProbe(ModePassthru, func() (string, error) { return "secret", nil }, false, time.Second, PassthruConfig{}, allowed)
+
+	tests := []string{
+		"http://db-1.example.com:8529",
+		"https://db-1.example.com.evil.com:8529",
+		"https://evil.com/?https://db-1.example.com:8529",
+		"localhost:8529",
+	}
+	for _, target := range tests {
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest("GET", "/probe?target="+url.QueryEscape(target), nil))
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("Probe of '%s' responded with status %d, expected %d", target, rec.Code, http.StatusForbidden)
+		}
+	}
+
+	if _, err := compileProbeTargets([]string{"("}); err == nil {
+		t.Error("compileProbeTargets succeeded for an invalid pattern, expected an error")
+	}
+}
+
+// TestProbeHandlers tests probes of an allowed target in various modes, reusing the handler of the target.
+func TestProbeHandlers(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/_api/version":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"server":"arango","version":"3.7.0"}`))
+		case "/_admin/metrics":
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte("# TYPE arangodb_client_connections gauge\narangodb_client_connections 3\n"))
+		case "/_admin/statistics-description":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"groups":[{"group":"client","name":"Client","description":"Client"}],"figures":[
+				{"group":"client","identifier":"httpConnections","name":"Client Connections","description":"Connections","type":"current"}]}`))
+		case "/_admin/statistics":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"client":{"httpConnections":4}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	allowed, err := compileProbeTargets([]string{`http://127\.0\.0\.1:[0-9]+`})
+	if err != nil {
+		t.Fatalf("compileProbeTargets failed: %v", err)
+	}
+	p := NewProbe(ModePassthru, func() (string, error) { return "", nil }, false, time.Second, PassthruConfig{MetricsAPI: MetricsAPIAuto}, allowed).(*probe)
+
+	tests := []struct {
+		Mode     string
+		Status   int
+		Expected string
+		Targets  int
+	}{
+		{"", http.StatusOK, "arangodb_client_connections 3", 1},
+		{"passthru", http.StatusOK, "arangodb_client_connections 3", 1}, // The default mode, same handler
+		{"internal", http.StatusOK, "arangodb_client_client_connections 4", 2},
+		{"internal", http.StatusOK, "arangodb_client_client_connections 4", 2},
+		{"unknown", http.StatusBadRequest, "Unknown mode 'unknown'", 2},
+	}
+
+	handlers := make(map[probeKey]*probeTarget)
+	for i, test := range tests {
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest("GET", "/probe?target="+url.QueryEscape(server.URL)+"&mode="+test.Mode, nil))
+		if rec.Code != test.Status {
+			t.Errorf("Probe for test %d responded with status %d, expected %d", i, rec.Code, test.Status)
+		}
+		if body := rec.Body.String(); !strings.Contains(body, test.Expected) {
+			t.Errorf("Probe for test %d failed: expected %q in\n%s", i, test.Expected, body)
+		}
+
+		p.mutex.Lock()
+		if len(p.targets) != test.Targets {
+			t.Errorf("Probe for test %d failed: got %d handlers, expected %d", i, len(p.targets), test.Targets)
+		}
+		for key, target := range p.targets {
+			if h, found := handlers[key]; found && h != target {
+				t.Errorf("Probe for test %d failed: the handler of %s in %s mode was created again", i, key.endpoint, key.mode)
+			}
+			handlers[key] = target
+		}
+		p.mutex.Unlock()
+	}
+}
@@ -0,0 +1,207 @@
+//
+// DISCLAIMER
+//
+// Copyright 2018 ArangoDB GmbH, Cologne, Germany
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Copyright holder is ArangoDB GmbH, Cologne, Germany
+//
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+
+	driver "github.com/arangodb/go-driver"
+	"github.com/pkg/errors"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/log"
+)
+
+const (
+	// MetricsAPIAuto selects the metrics API matching the version of the server.
+	MetricsAPIAuto = "auto"
+	// MetricsAPIV1 selects the `_admin/metrics` API.
+	MetricsAPIV1 = "v1"
+	// MetricsAPIV2 selects the `_admin/metrics/v2` API.
+	MetricsAPIV2 = "v2"
+
+	metricsPathV1 = "_admin/metrics"
+	metricsPathV2 = "_admin/metrics/v2"
+
+	// metricsV2MinVersion is the first ArangoDB version that provides the `_admin/metrics/v2` API.
+	metricsV2MinVersion = driver.Version("3.8.0")
+)
+
+// isValidMetricsAPI returns true if the given metrics API is supported.
+func isValidMetricsAPI(api string) bool {
+	switch api {
+	case MetricsAPIAuto, MetricsAPIV1, MetricsAPIV2:
+		return true
+	default:
+		return false
+	}
+}
+
+// metricsAPI selects the path of the metrics API of a server.
+type metricsAPI struct {
+	getJSON func(ctx context.Context, path string, result interface{}) error
+	api     string
+
+	mutex sync.Mutex
+	path  string
+}
+
+// get returns the path of the metrics API to use.
+// In auto mode, the API is selected by the version of the server, which is detected once.
+// When detection fails, the v1 API is used until the next attempt.
+func (m *metricsAPI) get(ctx context.Context) string {
+	switch m.api {
+	case MetricsAPIV1:
+		return metricsPathV1
+	case MetricsAPIV2:
+		return metricsPathV2
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.path != "" {
+		return m.path
+	}
+
+	var v driver.VersionInfo
+	if err := m.getJSON(ctx, "_api/version", &v); err != nil {
+		log.Warnf("Failed to detect ArangoDB version: %v", err)
+		return metricsPathV1
+	}
+	if v.Version.CompareTo(metricsV2MinVersion) >= 0 {
+		m.path = metricsPathV2
+	} else {
+		m.path = metricsPathV1
+	}
+	log.Infof("Using %s for ArangoDB %s", m.path, v.Version)
+	return m.path
+}
+
+// reset ensures the API is selected again, e.g. because the server may have been upgraded.
+func (m *metricsAPI) reset() {
+	if m == nil {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.path = ""
+}
+
+// dbServers returns the IDs of all DB-Servers of the cluster, sorted, together with the health of all servers.
+func (p passthru) dbServers(ctx context.Context) ([]driver.ServerID, map[driver.ServerID]driver.ServerHealth, error) {
+	var health driver.ClusterHealth
+	if err := p.getJSON(ctx, "_admin/cluster/health", &health); err != nil {
+		return nil, nil, maskAny(err)
+	}
+
+	var ids []driver.ServerID
+	for id, h := range health.Health {
+		if h.Role == driver.ServerRoleDBServer {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+	return ids, health.Health, nil
+}
+
+// streamCluster fetches the metrics of the coordinator, together with the metrics of all DB-Servers
+// of its cluster, which are fetched through the coordinator with the serverId parameter.
+// DB-Server metrics are labeled with the server_id, role & short_name of the DB-Server.
+// Since metric families of all servers have to be merged, they are collected before fn is called.
+// DB-Servers are scraped using the same metrics API as the coordinator.
+// A DB-Server that cannot be scraped, or whose metrics cannot be parsed, does not fail the scrape.
+// It is only reported in the proxy up metric, its metrics are left out.
+func (p passthru) streamCluster(ctx context.Context, fn func(*dto.MetricFamily) error) error {
+	families := make(map[string]*dto.MetricFamily)
+	var lastParseErr error
+	// scrape merges the metrics of a server into families, once all of them have been read
+	scrape := func(path string, proxied bool, labels map[string]string) error {
+		var server []*dto.MetricFamily
+		err := p.streamServer(ctx, path, proxied, func(mf *dto.MetricFamily) error {
+			addLabels(mf, labels)
+			server = append(server, mf)
+			return nil
+		})
+		if _, ok := errors.Cause(err).(parseError); ok && !proxied {
+			lastParseErr = err
+		} else if err != nil {
+			return maskAny(err)
+		}
+		for _, mf := range server {
+			mergeFamily(families, mf)
+		}
+		return nil
+	}
+
+	api := p.api.get(ctx)
+	if err := scrape(api, false, nil); err != nil {
+		return maskAny(err)
+	}
+
+	ids, health, err := p.dbServers(ctx)
+	if err != nil {
+		log.Warnf("Failed to fetch DB-Servers of the cluster: %v", err)
+	}
+	p.proxyUp.Reset()
+	for _, id := range ids {
+		labels := map[string]string{
+			"server_id":  string(id),
+			"role":       roleLabel(string(driver.ServerRoleDBServer)),
+			"short_name": health[id].ShortName,
+		}
+		path := fmt.Sprintf("%s?serverId=%s", api, url.QueryEscape(string(id)))
+		if err := scrape(path, true, labels); err != nil {
+			log.Warnf("Failed to fetch metrics of DB-Server %s: %v", id, err)
+			p.proxyUp.WithLabelValues(string(id)).Set(0)
+			continue
+		}
+		p.proxyUp.WithLabelValues(string(id)).Set(1)
+	}
+
+	for _, mf := range sortFamilies(families) {
+		if err := fn(mf); err != nil {
+			return maskAny(err)
+		}
+	}
+	return lastParseErr
+}
+
+// mergeFamily adds the metrics of the given metric family to the family with the same name in families.
+// Metric families with a type that differs from the existing family are dropped.
+func mergeFamily(families map[string]*dto.MetricFamily, mf *dto.MetricFamily) {
+	existing, found := families[mf.GetName()]
+	if !found {
+		families[mf.GetName()] = mf
+		return
+	}
+	if existing.GetType() != mf.GetType() {
+		log.Debugf("Dropped metric family %s, its type differs from the same family of other servers", mf.GetName())
+		return
+	}
+	existing.Metric = append(existing.Metric, mf.Metric...)
+}
@@ -0,0 +1,170 @@
+//
+// DISCLAIMER
+//
+// Copyright 2018 ArangoDB GmbH, Cologne, Germany
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Copyright holder is ArangoDB GmbH, Cologne, Germany
+//
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestMetricsAPI tests the metrics API selected for various server versions.
+func TestMetricsAPI(t *testing.T) {
+	tests := []struct {
+		API     string
+		Version string
+		Path    string
+	}{
+		{MetricsAPIAuto, "3.7.12", metricsPathV1},
+		{MetricsAPIAuto, "3.8.0", metricsPathV2},
+		{MetricsAPIAuto, "3.10.1", metricsPathV2},
+		{MetricsAPIAuto, "", metricsPathV1},
+		{MetricsAPIV1, "3.10.1", metricsPathV1},
+		{MetricsAPIV2, "3.7.12", metricsPathV2},
+	}
+
+	for i, test := range tests {
+		api := &metricsAPI{api: test.API, getJSON: func(ctx context.Context, path string, result interface{}) error {
+			if test.Version == "" {
+				return fmt.Errorf("Unavailable")
+			}
+			return json.Unmarshal([]byte(fmt.Sprintf(`{"server":"arango","version":"%s"}`, test.Version)), result)
+		}}
+		if result := api.get(context.Background()); result != test.Path {
+			t.Errorf("get for test %d (%s, %s) failed: got %s, expected %s", i, test.API, test.Version, result, test.Path)
+		}
+	}
+}
+
+// TestPassthruProxyDBServers tests the metrics of DB-Servers fetched through a coordinator, using the
+// metrics API of the coordinator. Failed DB-Servers are only reported in the proxy up metric.
+func TestPassthruProxyDBServers(t *testing.T) {
+	tests := []struct {
+		API  string
+		Path string
+	}{
+		{MetricsAPIAuto, "/_admin/metrics/v2"},
+		{MetricsAPIV1, "/_admin/metrics"},
+	}
+
+	for i, test := range tests {
+		var proxied []string
+		coordinator := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/_api/version":
+				w.Write([]byte(`{"server":"arango","version":"3.8.1"}`))
+			case "/_admin/cluster/health":
+				w.Write([]byte(`{"ClusterId":"c","Health":{
+					"CRDN-1":{"Role":"Coordinator","ShortName":"Coordinator0001"},
+					"PRMR-1":{"Role":"DBServer","ShortName":"DBServer0001"},
+					"PRMR-2":{"Role":"DBServer","ShortName":"DBServer0002"},
+					"PRMR-3":{"Role":"DBServer","ShortName":"DBServer0003"}}}`))
+			case "/_admin/metrics", "/_admin/metrics/v2":
+				w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+				id := r.URL.Query().Get("serverId")
+				if id != "" {
+					proxied = append(proxied, r.URL.Path)
+				}
+				switch id {
+				case "":
+					w.Write([]byte("# TYPE arangodb_client_connections gauge\narangodb_client_connections 3\n"))
+				case "PRMR-1":
+					w.Write([]byte("# TYPE arangodb_client_connections gauge\narangodb_client_connections 5\n"))
+				case "PRMR-3":
+					w.Write([]byte("# TYPE arangodb_client_connections gauge\narangodb_client_connections 7\narangodb_client_connections{\n"))
+				default:
+					http.Error(w, "unavailable", http.StatusServiceUnavailable)
+				}
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+
+		auth := func() (string, error) { return "", nil }
+		p := newPassthru(coordinator.URL, auth, false, time.Second, PassthruConfig{MetricsAPI: test.API, ProxyDBServers: true})
+
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		coordinator.Close()
+		if rec.Code != http.StatusOK {
+			t.Errorf("ServeHTTP for test %d failed: got status %d, expected %d", i, rec.Code, http.StatusOK)
+			continue
+		}
+		body := rec.Body.String()
+		for _, expected := range []string{
+			"arangodb_client_connections 3\n",
+			`arangodb_client_connections{role="dbserver",server_id="PRMR-1",short_name="DBServer0001"} 5`,
+			`arangodb_exporter_passthru_proxy_up{server_id="PRMR-1"} 1`,
+			`arangodb_exporter_passthru_proxy_up{server_id="PRMR-2"} 0`,
+			`arangodb_exporter_passthru_proxy_up{server_id="PRMR-3"} 0`,
+			`arangodb_exporter_passthru_scrape_errors{reason="parse"} 0`,
+			`arangodb_exporter_passthru_scrape_errors{reason="status"} 0`,
+			"arangodb_exporter_passthru_parse_error 0",
+			"arangodb_up 1",
+		} {
+			if !strings.Contains(body, expected) {
+				t.Errorf("ServeHTTP for test %d failed: expected %q in\n%s", i, expected, body)
+			}
+		}
+		if strings.Contains(body, "PRMR-3\",short_name") {
+			t.Errorf("ServeHTTP for test %d failed: got metrics of a DB-Server that cannot be parsed in\n%s", i, body)
+		}
+		if n := strings.Count(body, "# TYPE arangodb_client_connections "); n != 1 {
+			t.Errorf("ServeHTTP for test %d failed: got %d TYPE lines of arangodb_client_connections, expected 1", i, n)
+		}
+		for _, path := range proxied {
+			if path != test.Path {
+				t.Errorf("ServeHTTP for test %d failed: DB-Server metrics fetched from %s, expected %s", i, path, test.Path)
+			}
+		}
+		if len(proxied) < 3 {
+			t.Errorf("ServeHTTP for test %d failed: got %d DB-Server requests, expected at least 3", i, len(proxied))
+		}
+	}
+}
+
+// TestPassthruDBServersLargeCluster tests fetching the DB-Servers of a cluster with a large health response.
+func TestPassthruDBServersLargeCluster(t *testing.T) {
+	const count = 2000
+	var health []string
+	for i := 0; i < count; i++ {
+		health = append(health, fmt.Sprintf(`"PRMR-%04d":{"Role":"DBServer","ShortName":"DBServer%04d","Endpoint":"tcp://dbserver-%04d.example.com:8529"}`, i, i, i))
+	}
+	body := `{"ClusterId":"c","Health":{` + strings.Join(health, ",") + `}}`
+	coordinator := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer coordinator.Close()
+
+	p := newPassthru(coordinator.URL, func() (string, error) { return "", nil }, false, time.Second, PassthruConfig{ProxyDBServers: true})
+	ids, _, err := p.dbServers(context.Background())
+	if err != nil {
+		t.Fatalf("dbServers for a health response of %d bytes failed: %v", len(body), err)
+	}
+	if len(ids) != count {
+		t.Errorf("dbServers failed: got %d DB-Servers, expected %d", len(ids), count)
+	}
+}
@@ -0,0 +1,296 @@
+//
+// DISCLAIMER
+//
+// Copyright 2018 ArangoDB GmbH, Cologne, Germany
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Copyright holder is ArangoDB GmbH, Cologne, Germany
+//
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RetryConfig configures the retries & circuit breaker of requests to ArangoDB.
+type RetryConfig struct {
+	Retries          int           // Number of times a failed request is retried
+	Backoff          time.Duration // Delay before the first retry, doubled for every next retry
+	MaxBackoff       time.Duration // Maximum delay between retries
+	BreakerThreshold int           // Number of consecutive failed requests that open the circuit breaker, 0 disables it
+	BreakerCooldown  time.Duration // Time after which an open circuit breaker lets a request through
+}
+
+// retryOptions holds the retry configuration used for all requests to ArangoDB.
+var retryOptions = RetryConfig{
+	Retries:          2,
+	Backoff:          100 * time.Millisecond,
+	MaxBackoff:       2 * time.Second,
+	BreakerThreshold: 5,
+	BreakerCooldown:  30 * time.Second,
+}
+
+const (
+	breakerClosed   = "closed"    // Requests are sent
+	breakerOpen     = "open"      // Requests fail without being sent
+	breakerHalfOpen = "half_open" // A single request is sent to test the server
+)
+
+// breakerStates lists all states of a circuit breaker.
+var breakerStates = []string{breakerClosed, breakerOpen, breakerHalfOpen}
+
+// noRetryKey is the context key marking requests that are not retried.
+type noRetryKey struct{}
+
+// withoutRetries returns a context for requests whose error status is an expected answer,
+// such as the availability of a follower. These requests are not retried and only
+// connection failures count for the circuit breaker.
+func withoutRetries(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noRetryKey{}, true)
+}
+
+// errCircuitOpen is returned for requests that are not sent because the circuit breaker is open.
+var errCircuitOpen = fmt.Errorf("Circuit breaker is open, ArangoDB failed too many requests")
+
+// circuitBreaker stops requests to a server after a number of consecutive failures.
+// After a cooldown a single request is let through; its success closes the breaker again.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mutex    sync.Mutex
+	state    string
+	failures int
+	opened   time.Time
+}
+
+// allow returns true if a request may be sent.
+func (b *circuitBreaker) allow() bool {
+	if b.threshold <= 0 {
+		return true
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.opened) < b.cooldown {
+			return false
+		}
+		b.state = breakerHalfOpen
+		return true
+	case breakerHalfOpen:
+		// Another request is testing the server
+		return false
+	default:
+		return true
+	}
+}
+
+// success records a successful request.
+func (b *circuitBreaker) success() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.state = breakerClosed
+	b.failures = 0
+}
+
+// failure records a failed request.
+func (b *circuitBreaker) failure() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.failures++
+	if b.threshold > 0 && (b.state == breakerHalfOpen || b.failures >= b.threshold) {
+		b.state = breakerOpen
+		b.opened = time.Now()
+	}
+}
+
+// abort records a request that ended without telling anything about the server,
+// because the caller gave up. A request testing the server can be sent again right away.
+func (b *circuitBreaker) abort() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.state == breakerHalfOpen {
+		// The cooldown has already passed
+		b.state = breakerOpen
+	}
+}
+
+// reset closes the breaker, e.g. because requests are sent to another server.
+func (b *circuitBreaker) reset() {
+	b.success()
+}
+
+// current returns the state of the breaker.
+func (b *circuitBreaker) current() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.state == "" {
+		return breakerClosed
+	}
+	return b.state
+}
+
+// retryTransport is a http.RoundTripper that retries failed idempotent requests with jittered backoff,
+// as long as the deadline of the request allows, and guards the server with a circuit breaker.
+// It exposes the state of the breaker & the number of retries as metrics.
+type retryTransport struct {
+	next    http.RoundTripper
+	cfg     RetryConfig
+	breaker *circuitBreaker
+
+	retries      prometheus.Counter
+	breakerState *prometheus.Desc
+}
+
+// newRetryTransport returns a retryTransport sending requests through the given transport.
+// The given client & labels are added to its metrics, to tell the transports of a target apart.
+func newRetryTransport(next http.RoundTripper, cfg RetryConfig, client string, labels prometheus.Labels) *retryTransport {
+	constLabels := prometheus.Labels{"client": client}
+	for k, v := range labels {
+		constLabels[k] = v
+	}
+	return &retryTransport{
+		next:    next,
+		cfg:     cfg,
+		breaker: &circuitBreaker{threshold: cfg.BreakerThreshold, cooldown: cfg.BreakerCooldown},
+		retries: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "exporter_upstream_retries",
+			Help:        "Number of retried requests to ArangoDB.",
+			ConstLabels: constLabels,
+		}),
+		breakerState: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "exporter_circuit_breaker_state"),
+			"State of the circuit breaker guarding requests to ArangoDB.", []string{"state"}, constLabels),
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+// Requests that fail because their own context ended are neither retried nor counted by the circuit breaker,
+// since the caller gave up on them, e.g. because the scrape deadline passed.
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.breaker.allow() {
+		return nil, errCircuitOpen
+	}
+	ctx := req.Context()
+	if ctx.Value(noRetryKey{}) != nil {
+		resp, err := t.next.RoundTrip(req)
+		if err != nil && ctx.Err() != nil {
+			t.breaker.abort()
+		} else if err != nil {
+			t.breaker.failure()
+		} else {
+			t.breaker.success()
+		}
+		return resp, err
+	}
+	idempotent := (req.Method == http.MethodGet || req.Method == http.MethodHead) && req.Body == nil
+	for attempt := 0; ; attempt++ {
+		resp, err := t.next.RoundTrip(req)
+		if err != nil && ctx.Err() != nil {
+			t.breaker.abort()
+			return resp, err
+		}
+		if !isRetryable(resp, err) {
+			t.breaker.success()
+			return resp, err
+		}
+		if !idempotent || attempt >= t.cfg.Retries {
+			t.breaker.failure()
+			return resp, err
+		}
+		delay := t.backoff(attempt)
+		if deadline, found := ctx.Deadline(); found && time.Until(deadline) < delay {
+			// Another attempt would not finish in time
+			t.breaker.failure()
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxDrainSize))
+			resp.Body.Close()
+		}
+		t.retries.Inc()
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			// The failed attempt is counted, the attempts that were not made are not
+			t.breaker.failure()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// backoff returns the delay before the retry following the given attempt.
+// The delay is doubled for every attempt, half of it is random.
+func (t *retryTransport) backoff(attempt int) time.Duration {
+	delay := t.cfg.Backoff << uint(attempt)
+	if t.cfg.MaxBackoff > 0 && (delay > t.cfg.MaxBackoff || delay <= 0) {
+		delay = t.cfg.MaxBackoff
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// isRetryable returns true if the given result of a request indicates that the server is (temporarily) unavailable.
+func isRetryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// CloseIdleConnections closes the idle connections of the underlying transport.
+func (t *retryTransport) CloseIdleConnections() {
+	if c, ok := t.next.(idleCloser); ok {
+		c.CloseIdleConnections()
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (t *retryTransport) Describe(ch chan<- *prometheus.Desc) {
+	ch <- t.retries.Desc()
+	ch <- t.breakerState
+}
+
+// Collect implements prometheus.Collector.
+func (t *retryTransport) Collect(ch chan<- prometheus.Metric) {
+	ch <- t.retries
+	current := t.breaker.current()
+	for _, state := range breakerStates {
+		value := 0.0
+		if state == current {
+			value = 1
+		}
+		ch <- prometheus.MustNewConstMetric(t.breakerState, prometheus.GaugeValue, value, state)
+	}
+}
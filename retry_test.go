@@ -0,0 +1,134 @@
+//
+// DISCLAIMER
+//
+// Copyright 2018 ArangoDB GmbH, Cologne, Germany
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Copyright holder is ArangoDB GmbH, Cologne, Germany
+//
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestRetryTransport tests the retries of failed requests for various numbers of failures.
+func TestRetryTransport(t *testing.T) {
+	tests := []struct {
+		Method   string
+		NoRetry  bool
+		Failures int32
+		Status   int
+		Attempts int32
+	}{
+		{"GET", false, 0, http.StatusOK, 1},
+		{"GET", false, 2, http.StatusOK, 3},
+		{"GET", false, 3, http.StatusServiceUnavailable, 3},
+		{"POST", false, 1, http.StatusServiceUnavailable, 1},
+		{"GET", true, 1, http.StatusServiceUnavailable, 1},
+	}
+
+	for i, test := range tests {
+		var attempts int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&attempts, 1) <= test.Failures {
+				http.Error(w, "starting", http.StatusServiceUnavailable)
+			}
+		}))
+		cfg := RetryConfig{Retries: 2, Backoff: time.Millisecond}
+		transport := newRetryTransport(http.DefaultTransport, cfg, "test", nil)
+		req, _ := http.NewRequest(test.Method, server.URL, nil)
+		if test.NoRetry {
+			req = req.WithContext(withoutRetries(req.Context()))
+		}
+		resp, err := transport.RoundTrip(req)
+		if err != nil {
+			t.Errorf("RoundTrip for test %d failed: %v", i, err)
+		} else {
+			resp.Body.Close()
+			if resp.StatusCode != test.Status {
+				t.Errorf("RoundTrip for test %d failed: got status %d, expected %d", i, resp.StatusCode, test.Status)
+			}
+		}
+		if n := atomic.LoadInt32(&attempts); n != test.Attempts {
+			t.Errorf("RoundTrip for test %d failed: got %d attempts, expected %d", i, n, test.Attempts)
+		}
+		server.Close()
+	}
+}
+
+// TestCircuitBreaker tests the state transitions of the circuit breaker.
+func TestCircuitBreaker(t *testing.T) {
+	b := &circuitBreaker{threshold: 2, cooldown: 50 * time.Millisecond}
+	expect := func(step string, allowed bool, state string) {
+		if result := b.allow(); result != allowed {
+			t.Errorf("allow after %s failed: got %v, expected %v", step, result, allowed)
+		}
+		if result := b.current(); result != state {
+			t.Errorf("current after %s failed: got %s, expected %s", step, result, state)
+		}
+	}
+
+	expect("start", true, breakerClosed)
+	b.failure()
+	expect("first failure", true, breakerClosed)
+	b.failure()
+	expect("second failure", false, breakerOpen)
+	time.Sleep(60 * time.Millisecond)
+	expect("cooldown", true, breakerHalfOpen)
+	expect("concurrent request", false, breakerHalfOpen)
+	b.failure()
+	expect("failed test request", false, breakerOpen)
+	time.Sleep(60 * time.Millisecond)
+	expect("second cooldown", true, breakerHalfOpen)
+	b.success()
+	expect("successful test request", true, breakerClosed)
+}
+
+// TestRetryTransportCallerTimeout tests that requests given up by their caller are neither retried
+// nor counted by the circuit breaker.
+func TestRetryTransportCallerTimeout(t *testing.T) {
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	cfg := RetryConfig{Retries: 2, Backoff: time.Millisecond, BreakerThreshold: 1, BreakerCooldown: time.Minute}
+	transport := newRetryTransport(http.DefaultTransport, cfg, "test", nil)
+	for _, noRetry := range []bool{false, true} {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		if noRetry {
+			ctx = withoutRetries(ctx)
+		}
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		if _, err := transport.RoundTrip(req.WithContext(ctx)); err == nil {
+			t.Errorf("RoundTrip (no retry %v) succeeded, expected an error", noRetry)
+		}
+		cancel()
+		if state := transport.breaker.current(); state != breakerClosed {
+			t.Errorf("Circuit breaker (no retry %v) is %s, expected %s", noRetry, state, breakerClosed)
+		}
+	}
+	if n := atomic.LoadInt32(&attempts); n != 2 {
+		t.Errorf("Got %d attempts, expected 2", n)
+	}
+}
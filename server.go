@@ -23,6 +23,7 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"net/http"
 	_ "net/http/pprof"
@@ -67,7 +68,7 @@ func NewServer(handler http.Handler, cfg ServerConfig) (*Server, error) {
 	}, nil
 }
 
-// Run the server until the program stops.
+// Run the server until the program stops or the server is shut down.
 func (s *Server) Run() error {
 	if s.httpServer.TLSConfig != nil {
 		if err := s.httpServer.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
@@ -81,6 +82,15 @@ func (s *Server) Run() error {
 	return nil
 }
 
+// Shutdown stops the server gracefully, waiting for active requests until the given context is done.
+// Run returns once the server is stopped.
+func (s *Server) Shutdown(ctx context.Context) error {
+	if err := s.httpServer.Shutdown(ctx); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
+
 // createTLSConfig creates a TLS config from the given keyfile.
 func createTLSConfig(keyfile string) (*tls.Config, error) {
 	cert, err := certificates.LoadKeyFile(keyfile)
@@ -0,0 +1,98 @@
+//
+// DISCLAIMER
+//
+// Copyright 2018 ArangoDB GmbH, Cologne, Germany
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Copyright holder is ArangoDB GmbH, Cologne, Germany
+//
+
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// serverStatistic is a value of the _admin/statistics response that may not be
+// listed in the statistics description, exported with a fixed name & type.
+type serverStatistic struct {
+	Path []string // Group, nested groups & key of the value
+	Name string
+	Help string
+	Type prometheus.ValueType
+}
+
+// serverStatistics lists the values of the server & system groups of the statistics.
+var serverStatistics = []serverStatistic{
+	{[]string{"server", "uptime"}, "server_uptime_seconds", "Number of seconds the server is running.", prometheus.GaugeValue},
+	{[]string{"server", "physicalMemory"}, "server_physical_memory_bytes", "Physical memory of the machine of the server.", prometheus.GaugeValue},
+	{[]string{"server", "transactions", "started"}, "server_transactions_started_total", "Number of started transactions.", prometheus.CounterValue},
+	{[]string{"server", "transactions", "committed"}, "server_transactions_committed_total", "Number of committed transactions.", prometheus.CounterValue},
+	{[]string{"server", "transactions", "aborted"}, "server_transactions_aborted_total", "Number of aborted transactions.", prometheus.CounterValue},
+	{[]string{"server", "transactions", "intermediateCommits"}, "server_transactions_intermediate_commits_total", "Number of intermediate commits of transactions.", prometheus.CounterValue},
+	{[]string{"server", "v8Context", "available"}, "server_v8_contexts_available", "Number of V8 contexts that are created.", prometheus.GaugeValue},
+	{[]string{"server", "v8Context", "busy"}, "server_v8_contexts_busy", "Number of V8 contexts that are in use.", prometheus.GaugeValue},
+	{[]string{"server", "v8Context", "dirty"}, "server_v8_contexts_dirty", "Number of V8 contexts that wait for garbage collection.", prometheus.GaugeValue},
+	{[]string{"server", "v8Context", "free"}, "server_v8_contexts_free", "Number of V8 contexts that can be used, 0 when all contexts are exhausted.", prometheus.GaugeValue},
+	{[]string{"server", "v8Context", "min"}, "server_v8_contexts_min", "Minimum number of V8 contexts.", prometheus.GaugeValue},
+	{[]string{"server", "v8Context", "max"}, "server_v8_contexts_max", "Maximum number of V8 contexts.", prometheus.GaugeValue},
+	{[]string{"server", "threads", "scheduler-threads"}, "server_scheduler_threads", "Number of threads of the scheduler.", prometheus.GaugeValue},
+	{[]string{"server", "threads", "in-progress"}, "server_scheduler_in_progress", "Number of requests that are processed by the scheduler.", prometheus.GaugeValue},
+	{[]string{"server", "threads", "queued"}, "server_scheduler_queue_length", "Number of requests that are queued by the scheduler.", prometheus.GaugeValue},
+	{[]string{"server", "threads", "blocked"}, "server_scheduler_blocked", "Number of threads of the scheduler that are blocked.", prometheus.GaugeValue},
+	{[]string{"system", "minorPageFaults"}, "system_minor_page_faults_total", "Number of minor page faults of the server process.", prometheus.CounterValue},
+	{[]string{"system", "majorPageFaults"}, "system_major_page_faults_total", "Number of major page faults of the server process.", prometheus.CounterValue},
+	{[]string{"system", "userTime"}, "system_user_time_seconds_total", "User CPU time of the server process.", prometheus.CounterValue},
+	{[]string{"system", "systemTime"}, "system_system_time_seconds_total", "System CPU time of the server process.", prometheus.CounterValue},
+	{[]string{"system", "numberOfThreads"}, "system_threads", "Number of threads of the server process.", prometheus.GaugeValue},
+	{[]string{"system", "residentSize"}, "system_resident_size_bytes", "Resident memory of the server process.", prometheus.GaugeValue},
+	{[]string{"system", "residentSizePercent"}, "system_resident_size_ratio", "Resident memory of the server process, relative to the physical memory.", prometheus.GaugeValue},
+	{[]string{"system", "virtualSize"}, "system_virtual_size_bytes", "Virtual memory of the server process.", prometheus.GaugeValue},
+}
+
+// serverStatisticsMetrics creates the metrics for the server statistics found in the given statistics.
+// Values that are listed in the given description are skipped, since they are exported as figures.
+// The given labels are added to all created metrics.
+func serverStatisticsMetrics(stats Statistics, descr StatisticsDescription, labels prometheus.Labels) []prometheus.Metric {
+	described := make(map[string]struct{})
+	for _, f := range descr.Figures {
+		described[f.Group+"."+f.Identifier] = struct{}{}
+	}
+	var result []prometheus.Metric
+	for _, s := range serverStatistics {
+		if _, found := described[s.Path[0]+"."+s.Path[1]]; found {
+			continue
+		}
+		value, ok := s.get(stats)
+		if !ok {
+			continue
+		}
+		desc := prometheus.NewDesc(prometheus.BuildFQName(namespace, "", s.Name), s.Help, nil, labels)
+		result = append(result, prometheus.MustNewConstMetric(desc, s.Type, value))
+	}
+	return result
+}
+
+// get returns the value of the statistic from the given statistics.
+// If not found, false is returned.
+func (s serverStatistic) get(stats Statistics) (float64, bool) {
+	last := len(s.Path) - 1
+	for _, group := range s.Path[:last] {
+		stats = stats.GetGroup(group)
+		if stats == nil {
+			return 0, false
+		}
+	}
+	return stats.GetFloat(s.Path[last])
+}
@@ -0,0 +1,70 @@
+//
+// DISCLAIMER
+//
+// Copyright 2018 ArangoDB GmbH, Cologne, Germany
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Copyright holder is ArangoDB GmbH, Cologne, Germany
+//
+
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// TestServerStatisticsMetrics tests the metrics created from the server & system groups of the statistics.
+func TestServerStatisticsMetrics(t *testing.T) {
+	var stats Statistics
+	if err := json.Unmarshal([]byte(`{
+		"server":{"uptime":12.5,"physicalMemory":1024,"transactions":{"started":4,"committed":3},
+			"v8Context":{"free":0,"max":16},"threads":{"scheduler-threads":8,"queued":17}},
+		"system":{"minorPageFaults":7,"userTime":1.5}}`), &stats); err != nil {
+		t.Fatalf("Failed to parse statistics: %v", err)
+	}
+	descr := StatisticsDescription{Figures: []StatisticFigure{{Group: "system", Identifier: "userTime"}}}
+
+	expected := map[string]string{
+		"arangodb_server_uptime_seconds":               "gauge:<value:12.5 > ",
+		"arangodb_server_physical_memory_bytes":        "gauge:<value:1024 > ",
+		"arangodb_server_transactions_started_total":   "counter:<value:4 > ",
+		"arangodb_server_transactions_committed_total": "counter:<value:3 > ",
+		"arangodb_server_v8_contexts_free":             "gauge:<value:0 > ",
+		"arangodb_server_v8_contexts_max":              "gauge:<value:16 > ",
+		"arangodb_server_scheduler_threads":            "gauge:<value:8 > ",
+		"arangodb_server_scheduler_queue_length":       "gauge:<value:17 > ",
+		"arangodb_system_minor_page_faults_total":      "counter:<value:7 > ",
+	}
+	metrics := serverStatisticsMetrics(stats, descr, nil)
+	if len(metrics) != len(expected) {
+		t.Errorf("serverStatisticsMetrics returns unexpected #metrics: got %d, expected %d", len(metrics), len(expected))
+	}
+	for _, m := range metrics {
+		desc := m.Desc().String()
+		name := strings.SplitN(strings.TrimPrefix(desc, `Desc{fqName: "`), `"`, 2)[0]
+		var result dto.Metric
+		if err := m.Write(&result); err != nil {
+			t.Fatalf("Write of %s failed: %v", name, err)
+		}
+		if value, found := expected[name]; !found {
+			t.Errorf("serverStatisticsMetrics returns unexpected metric %s", name)
+		} else if result.String() != value {
+			t.Errorf("serverStatisticsMetrics returns wrong value for %s: got '%s', expected '%s'", name, result.String(), value)
+		}
+	}
+}